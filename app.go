@@ -1,17 +1,20 @@
 package main
 
 import (
+	"cid_retranslator/cidParser"
 	"cid_retranslator/client"
 	"cid_retranslator/config"
-	"cid_retranslator/queue"
+	"cid_retranslator/dispatch"
 	"cid_retranslator/server"
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
 	"os"
 	"os/signal"
 	"path/filepath"
+	"sort"
 	"strings"
 	"sync"
 	"syscall"
@@ -24,36 +27,51 @@ import (
 
 // App struct
 type App struct {
-	ctx        context.Context // Signal context for shutdown
-	wailsCtx   context.Context // Wails context for runtime calls
-	cfg        *config.Config
-	appQueue   *queue.Queue
-	tcpServer  *server.Server
-	tcpClient  *client.Client
-	logger     *slog.Logger
-	fileLogger *lumberjack.Logger // Store fileLogger for closing
-	cancelfunc context.CancelFunc
-	wg         sync.WaitGroup
-	logBuffer  []string
-	logMu      sync.RWMutex
-	startTime  time.Time
+	ctx         context.Context // Signal context for shutdown
+	wailsCtx    context.Context // Wails context for runtime calls
+	cfg         *config.Config
+	upstreams   []*upstream
+	downstreams []*downstream
+	router      *dispatch.Router
+	logger      *slog.Logger
+	fileLogger  *lumberjack.Logger // Store fileLogger for closing
+	cancelfunc  context.CancelFunc
+	wg          sync.WaitGroup
+	logBuffer   []string
+	logMu       sync.RWMutex
+	startTime   time.Time
 }
 
 // NewApp creates a new App application struct
 func NewApp() *App {
 	cfg := config.New()
 	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT)
-	sharedQueue := queue.New(cfg.Queue.BufferSize)
+	downstreams, err := openDownstreams(cfg)
+	if err != nil {
+		panic(err)
+	}
+	members := make([]dispatch.Member, len(downstreams))
+	for i, d := range downstreams {
+		members[i] = dispatch.Member{Name: d.name, Queue: d.queue}
+	}
+	router, err := dispatch.NewRouter(cfg.Routes, cfg.Queue.Priorities, members, cfg.Fanout.Ack, cfg.Fanout.Primary)
+	if err != nil {
+		panic(err)
+	}
+	upstreams, err := openUpstreams(cfg, router)
+	if err != nil {
+		panic(err)
+	}
 
 	app := &App{
-		ctx:        ctx,
-		cfg:        cfg,
-		appQueue:   queue.New(cfg.Queue.BufferSize),
-		tcpServer:  server.New(&cfg.Server, sharedQueue, &cfg.CIDRules),
-		tcpClient:  client.New(&cfg.Client, sharedQueue),
-		cancelfunc: cancel,
-		logBuffer:  make([]string, 0, 100),
-		startTime:  time.Now(),
+		ctx:         ctx,
+		cfg:         cfg,
+		upstreams:   upstreams,
+		downstreams: downstreams,
+		router:      router,
+		cancelfunc:  cancel,
+		logBuffer:   make([]string, 0, 100),
+		startTime:   time.Now(),
 	}
 
 	// Validate log file path and create directory if needed
@@ -162,16 +180,20 @@ func (a *App) Startup(ctx context.Context) {
 		systray.Run(a.onReady, a.onExit)
 	}()
 
-	// Start TCP server and client
-	a.wg.Add(2)
-	go func() {
-		defer a.wg.Done()
-		a.tcpServer.Run(a.ctx)
-	}()
-	go func() {
-		defer a.wg.Done()
-		a.tcpClient.Run(a.ctx)
-	}()
+	// Start listeners and clients
+	a.wg.Add(len(a.upstreams) + len(a.downstreams))
+	for _, u := range a.upstreams {
+		go func(s *server.Server) {
+			defer a.wg.Done()
+			s.Run(a.ctx)
+		}(u.server)
+	}
+	for _, d := range a.downstreams {
+		go func(c *client.Client) {
+			defer a.wg.Done()
+			c.Run(a.ctx)
+		}(d.client)
+	}
 
 	// go a.StartStatsEmitter(a.wailsCtx)
 	// go a.StartLogsEmitter(a.wailsCtx)
@@ -237,10 +259,16 @@ func (a *App) Quit() {
 func (a *App) Shutdown(ctx context.Context) {
 	a.logger.Info("Received shutdown signal, initiating graceful shutdown...")
 	a.cancelfunc()
-	a.tcpServer.Stop()
-	a.tcpClient.Stop()
+	for _, u := range a.upstreams {
+		u.server.Stop()
+	}
+	for _, d := range a.downstreams {
+		d.client.Stop()
+	}
 	a.wg.Wait()
-	a.appQueue.Close()
+	for _, d := range a.downstreams {
+		d.queue.Close()
+	}
 	if a.fileLogger != nil {
 		if err := a.fileLogger.Close(); err != nil {
 			fmt.Fprintf(os.Stderr, "Failed to close file logger: %v\n", err)
@@ -252,10 +280,25 @@ func (a *App) Shutdown(ctx context.Context) {
 
 // Stats and related methods
 type Stats struct {
-	Accepted   int    `json:"accepted"`
-	Rejected   int    `json:"rejected"`
-	Uptime     string `json:"uptime"`
-	Reconnects int    `json:"reconnects"`
+	Accepted         int                 `json:"accepted"`
+	Rejected         int                 `json:"rejected"`
+	Uptime           string              `json:"uptime"`
+	Reconnects       int                 `json:"reconnects"`
+	AccountMapHits   int64               `json:"accountMapHits"`
+	AccountMapMisses int64               `json:"accountMapMisses"`
+	Filters          []server.FilterStat `json:"filters"`
+	// Pending counts messages stored but not yet delivered downstream.
+	Pending int `json:"pending"`
+	// Duplicates counts resent copies ACKed without being forwarded.
+	Duplicates  int64                `json:"duplicates"`
+	DeadLetters int                  `json:"deadLetters"`
+	Clients     []ClientStats        `json:"clients"`
+	Routes      []dispatch.RouteStat `json:"routes"`
+	// CertExpiry is when the first listener TLS certificate expires, empty
+	// without TLS.
+	CertExpiry string             `json:"certExpiry"`
+	Access     server.AccessStats `json:"access"`
+	Listeners  []ListenerStats    `json:"listeners"`
 }
 
 func formatDuration(d time.Duration) string {
@@ -265,15 +308,50 @@ func formatDuration(d time.Duration) string {
 	return fmt.Sprintf("%02d:%02d:%02d", h, m, s)
 }
 
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format("2006-01-02 15:04:05")
+}
+
 func (a *App) GetStats() Stats {
-	accepted, rejected, reconnects, _ := a.tcpClient.GetQueueStats()
 	uptime := time.Since(a.startTime).Truncate(time.Second)
-	return Stats{
-		Accepted:   accepted,
-		Rejected:   rejected,
-		Uptime:     formatDuration(uptime),
-		Reconnects: reconnects,
+	mapHits, mapMisses := cidparser.AccountMapStats()
+	st := Stats{
+		Uptime:           formatDuration(uptime),
+		AccountMapHits:   mapHits,
+		AccountMapMisses: mapMisses,
+		Routes:           a.router.Stats(),
+	}
+
+	// Totals over all listeners
+	var certExpiry time.Time
+	for _, u := range a.upstreams {
+		ls := u.stats()
+		st.Filters = append(st.Filters, ls.Filters...)
+		st.Duplicates += ls.Duplicates
+		st.Access.Denied += ls.Access.Denied
+		st.Access.OverLimit += ls.Access.OverLimit
+		st.Access.RateLimited += ls.Access.RateLimited
+		if expiry := u.server.CertExpiry(); !expiry.IsZero() && (certExpiry.IsZero() || expiry.Before(certExpiry)) {
+			certExpiry = expiry
+		}
+		st.Listeners = append(st.Listeners, ls)
+	}
+	st.CertExpiry = formatTime(certExpiry)
+
+	// Totals over all central stations
+	for _, d := range a.downstreams {
+		cs := d.stats()
+		st.Accepted += cs.Accepted
+		st.Rejected += cs.Rejected
+		st.Reconnects += cs.Reconnects
+		st.Pending += cs.Pending
+		st.DeadLetters += cs.DeadLetters
+		st.Clients = append(st.Clients, cs)
 	}
+	return st
 }
 
 func (a *App) DomReady(ctx context.Context) {
@@ -320,8 +398,13 @@ func (a *App) StartLogsEmitter(ctx context.Context) {
 	}
 }
 
+// GetDevices lists the devices of every listener. The same ID may appear
+// once per listener.
 func (a *App) GetDevices() []server.Device {
-	devices := a.tcpServer.GetDevices()
+	var devices []server.Device
+	for _, u := range a.upstreams {
+		devices = append(devices, u.server.GetDevices()...)
+	}
 	return devices
 }
 
@@ -343,16 +426,157 @@ func (a *App) StartEmitter(ctx context.Context) {
 
 			st := a.GetStats()
 			runtime.EventsEmit(ctx, "stats_update", st)
+
+			runtime.EventsEmit(ctx, "connections_update", a.GetConnections())
+		}
+	}
+}
+
+func (a *App) GetHeartbeats() []server.Heartbeat {
+	var heartbeats []server.Heartbeat
+	for _, u := range a.upstreams {
+		heartbeats = append(heartbeats, u.server.GetHeartbeats()...)
+	}
+	return heartbeats
+}
+
+// GetConnections lists the open inbound connections of all listeners
+func (a *App) GetConnections() []server.ConnectionInfo {
+	var conns []server.ConnectionInfo
+	for _, u := range a.upstreams {
+		conns = append(conns, u.server.GetConnections()...)
+	}
+	return conns
+}
+
+// DisconnectConnection closes an inbound connection, e.g. of a misbehaving sender
+func (a *App) DisconnectConnection(id uint64) error {
+	for _, u := range a.upstreams {
+		if err := u.server.Disconnect(id); !errors.Is(err, server.ErrUnknownConnection) {
+			return err
 		}
 	}
+	return server.ErrUnknownConnection
 }
 
+// GetGlobalEvents returns the events of all listeners, newest first
 func (a *App) GetGlobalEvents() []server.GlobalEvent {
-	return a.tcpServer.GetGlobalEvents()
+	var events []server.GlobalEvent
+	for _, u := range a.upstreams {
+		events = append(events, u.server.GetGlobalEvents()...)
+	}
+	sort.SliceStable(events, func(i, j int) bool { return events[i].Time > events[j].Time })
+	return events
 }
 
+// GetDeviceEvents returns the events of a device on every listener, oldest
+// first; use GetListenerDeviceEvents when account numbers overlap
 func (a *App) GetDeviceEvents(id int) []server.Event {
-	return a.tcpServer.GetDeviceEvents(id)
+	events := []server.Event{}
+	for _, u := range a.upstreams {
+		events = append(events, u.server.GetDeviceEvents(id)...)
+	}
+	sort.SliceStable(events, func(i, j int) bool { return events[i].Time < events[j].Time })
+	return events
+}
+
+// GetListenerDeviceEvents returns the events of a device on one listener
+func (a *App) GetListenerDeviceEvents(listener string, id int) ([]server.Event, error) {
+	u := a.upstream(listener)
+	if u == nil {
+		return nil, fmt.Errorf("unknown listener '%s'", listener)
+	}
+	return u.server.GetDeviceEvents(id), nil
+}
+
+// GetDeadLetters lists messages the clients gave up on, oldest first per client
+func (a *App) GetDeadLetters() []DeadLetterInfo {
+	var letters []DeadLetterInfo
+	for _, d := range a.downstreams {
+		for _, dl := range d.queue.DeadLetters() {
+			letters = append(letters, DeadLetterInfo{Client: d.name, DeadLetter: dl})
+		}
+	}
+	return letters
+}
+
+// ReplayDeadLetter queues one dead letter for delivery again by the same client
+func (a *App) ReplayDeadLetter(clientName string, id uint64) error {
+	d := a.downstream(clientName)
+	if d == nil {
+		return fmt.Errorf("unknown client '%s'", clientName)
+	}
+	if err := d.queue.Replay(id); err != nil {
+		return err
+	}
+	a.logger.Info("Dead letter replayed", "client", clientName, "id", id)
+	return nil
+}
+
+// ReplayAllDeadLetters queues every dead letter again and returns how many were queued
+func (a *App) ReplayAllDeadLetters() (int, error) {
+	total := 0
+	for _, d := range a.downstreams {
+		n, err := d.queue.ReplayAll()
+		total += n
+		if err != nil {
+			a.logger.Info("Dead letters replayed", "count", total)
+			return total, fmt.Errorf("client %s: %w", d.name, err)
+		}
+	}
+	a.logger.Info("Dead letters replayed", "count", total)
+	return total, nil
+}
+
+// PurgeDeadLetters deletes all dead letters and returns how many were removed
+func (a *App) PurgeDeadLetters() int {
+	total := 0
+	for _, d := range a.downstreams {
+		total += d.queue.PurgeDeadLetters()
+	}
+	a.logger.Info("Dead letters purged", "count", total)
+	return total
+}
+
+// GetAccess returns the source lists and limits of a listener
+func (a *App) GetAccess(listener string) (config.AccessConfig, error) {
+	u := a.upstream(listener)
+	if u == nil {
+		return config.AccessConfig{}, fmt.Errorf("unknown listener '%s'", listener)
+	}
+	return u.server.Access(), nil
+}
+
+// SetAccess replaces the source lists and limits of a listener until the
+// next restart; edit config.yaml to keep them
+func (a *App) SetAccess(listener string, access config.AccessConfig) error {
+	u := a.upstream(listener)
+	if u == nil {
+		return fmt.Errorf("unknown listener '%s'", listener)
+	}
+	if err := u.server.SetAccess(access); err != nil {
+		return err
+	}
+	a.logger.Info("Access control updated", "listener", listener, "allow", access.Allow, "deny", access.Deny, "maxConnections", access.MaxConnections, "rateLimit", access.RateLimit)
+	return nil
+}
+
+func (a *App) upstream(name string) *upstream {
+	for _, u := range a.upstreams {
+		if u.name == name {
+			return u
+		}
+	}
+	return nil
+}
+
+func (a *App) downstream(name string) *downstream {
+	for _, d := range a.downstreams {
+		if d.name == name {
+			return d
+		}
+	}
+	return nil
 }
 
 func (a *App) Greet(name string) string {
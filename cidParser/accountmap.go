@@ -0,0 +1,212 @@
+package cidparser
+
+import (
+	"cid_retranslator/config"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrUnknownAccount is returned when the account map rejects an account it does not contain.
+var ErrUnknownAccount = errors.New("account not found in account map")
+
+// defaultReloadInterval limits how often the account map file is checked for changes.
+const defaultReloadInterval = 5 * time.Second
+
+// AccountTable is an old-account to new-account lookup table loaded from a CSV file.
+// The file is re-read when its size or modification time changes.
+type AccountTable struct {
+	path     string
+	interval time.Duration
+
+	mu        sync.RWMutex
+	reloading atomic.Bool // one refresh at a time
+	entries   map[int]int
+	modTime   time.Time
+	size      int64
+	checked   time.Time
+
+	hits   atomic.Int64
+	misses atomic.Int64
+}
+
+var accountTables = struct {
+	sync.Mutex
+	m map[string]*AccountTable
+}{m: make(map[string]*AccountTable)}
+
+// accountTableFor returns the shared table for the configured file, loading it
+// on first use. A file that cannot be loaded still yields an empty table,
+// which is retried every reload interval; the error is only returned by the
+// call that created it.
+func accountTableFor(cfg *config.AccountMapConfig) (*AccountTable, error) {
+	accountTables.Lock()
+	defer accountTables.Unlock()
+
+	if t, ok := accountTables.m[cfg.File]; ok {
+		return t, nil
+	}
+
+	interval := cfg.ReloadInterval
+	if interval <= 0 {
+		interval = defaultReloadInterval
+	}
+	t := &AccountTable{path: cfg.File, interval: interval, checked: time.Now()}
+	accountTables.m[cfg.File] = t
+	return t, t.load()
+}
+
+// LoadAccountMap loads the table for cfg ahead of the first message, so a
+// missing or broken file is reported at startup.
+func LoadAccountMap(cfg *config.AccountMapConfig) error {
+	_, err := accountTableFor(cfg)
+	return err
+}
+
+// AccountMapStats returns the number of account map hits and misses across all tables.
+func AccountMapStats() (hits, misses int64) {
+	accountTables.Lock()
+	defer accountTables.Unlock()
+
+	for _, t := range accountTables.m {
+		hits += t.hits.Load()
+		misses += t.misses.Load()
+	}
+	return hits, misses
+}
+
+// Lookup returns the translated account and whether it was found.
+func (t *AccountTable) Lookup(account int) (int, bool) {
+	t.refresh()
+
+	t.mu.RLock()
+	newAccount, ok := t.entries[account]
+	t.mu.RUnlock()
+
+	if ok {
+		t.hits.Add(1)
+	} else {
+		t.misses.Add(1)
+	}
+	return newAccount, ok
+}
+
+// Len returns the number of entries in the table.
+func (t *AccountTable) Len() int {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return len(t.entries)
+}
+
+// refresh reloads the file if it changed since the last load. A broken file
+// keeps the previous table in place. Lookups that find another refresh
+// running use the current table.
+func (t *AccountTable) refresh() {
+	if !t.due() || !t.reloading.CompareAndSwap(false, true) {
+		return
+	}
+	defer t.reloading.Store(false)
+	if !t.due() {
+		return // reloaded while we waited
+	}
+
+	info, err := os.Stat(t.path)
+	t.mu.Lock()
+	t.checked = time.Now()
+	changed := err == nil && (!info.ModTime().Equal(t.modTime) || info.Size() != t.size)
+	t.mu.Unlock()
+
+	if err != nil {
+		slog.Warn("Account map file unavailable, keeping previous table", "path", t.path, "error", err)
+		return
+	}
+	if !changed {
+		return
+	}
+	if err := t.load(); err != nil {
+		slog.Error("Failed to reload account map, keeping previous table", "path", t.path, "error", err)
+	}
+}
+
+func (t *AccountTable) due() bool {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return time.Since(t.checked) >= t.interval
+}
+
+// load reads the file. A broken file is remembered by size and modification
+// time, so it is not parsed again until it changes.
+func (t *AccountTable) load() error {
+	f, err := os.Open(t.path)
+	if err != nil {
+		return fmt.Errorf("error opening account map: %w", err)
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return fmt.Errorf("error reading account map: %w", err)
+	}
+	entries, err := readAccountMap(f)
+
+	t.mu.Lock()
+	t.modTime = info.ModTime()
+	t.size = info.Size()
+	t.checked = time.Now()
+	if err != nil {
+		t.mu.Unlock()
+		return fmt.Errorf("error reading account map %s: %w", t.path, err)
+	}
+	t.entries = entries
+	t.mu.Unlock()
+
+	slog.Info("Account map loaded", "path", t.path, "entries", len(entries))
+	return nil
+}
+
+// readAccountMap parses "old,new" rows. Lines starting with '#' are comments
+// and a non-numeric first row is treated as a header.
+func readAccountMap(r io.Reader) (map[int]int, error) {
+	reader := csv.NewReader(r)
+	reader.Comment = '#'
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	entries := make(map[int]int)
+	for row := 1; ; row++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return entries, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(record) < 2 {
+			return nil, fmt.Errorf("row %d: want 2 columns, got %d", row, len(record))
+		}
+
+		from, err := strconv.Atoi(strings.TrimSpace(record[0]))
+		if err != nil {
+			if row == 1 {
+				continue // header
+			}
+			return nil, fmt.Errorf("row %d: invalid account '%s'", row, record[0])
+		}
+		to, err := strconv.Atoi(strings.TrimSpace(record[1]))
+		if err != nil {
+			return nil, fmt.Errorf("row %d: invalid account '%s'", row, record[1])
+		}
+		if from < 0 || from > config.MaxAccount || to < 0 || to > config.MaxAccount {
+			return nil, fmt.Errorf("row %d: accounts must be within 0-%d", row, config.MaxAccount)
+		}
+		entries[from] = to
+	}
+}
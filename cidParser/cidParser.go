@@ -20,41 +20,125 @@ func IsMessageValid(message string, rules *config.CIDRules) bool {
 
 // ChangeAccountNumber modifies the account number in a message according to the rules.
 func ChangeAccountNumber(message []byte, rules *config.CIDRules) ([]byte, error) {
-	messageString := string(message)
+	// Only complete Contact ID frames can be rewritten.
+	if len(message) != FrameLength {
+		return nil, fmt.Errorf("invalid message length: got %d, want %d", len(message), FrameLength)
+	}
 
-	// Panic prevention: Check length before slicing.
-	// The length should be at least 15 to extract all parts.
-	if len(messageString) != 21 {
-		return nil, fmt.Errorf("invalid message length: got %d, want at least 15", len(messageString))
+	msg, err := Parse(message)
+	if err != nil {
+		return nil, err
 	}
+	original := msg.Account
 
-	firstPart := messageString[:7]
-	accountNumber := messageString[7:11]
-	messageCode := messageString[11:15]
-	secondPart := messageString[15:]
+	changeTestCode(msg, rules)
+	if msg.Account, err = remapAccount(msg.Account, rules); err != nil {
+		return nil, err
+	}
 
-	num, err := strconv.Atoi(accountNumber)
+	newMessage, err := msg.Encode()
 	if err != nil {
-		return nil, fmt.Errorf("error converting account number '%s': %w", accountNumber, err)
+		return nil, err
 	}
 
-	if num >= 2000 && num <= 2200 {
-		num = num + rules.AccNumAdd
+	slog.Debug("Changed account number", "original", original, "new", msg.Account)
+	return newMessage, nil
+}
+
+// remapAccount consults the account map first and then applies the first
+// matching account rule.
+func remapAccount(account int, rules *config.CIDRules) (int, error) {
+	if rules.AccountMap.File != "" {
+		table, err := accountTableFor(&rules.AccountMap)
+		if err != nil {
+			// The empty table is retried on ReloadInterval; until then the
+			// unknown-account policy decides.
+			slog.Error("Account map unavailable, applying the unknown-account policy", "path", rules.AccountMap.File, "error", err)
+		}
+		if newAccount, ok := table.Lookup(account); ok {
+			return newAccount, nil
+		}
+
+		switch rules.AccountMap.Unknown {
+		case config.UnknownAccountReject:
+			return 0, fmt.Errorf("account %04d: %w", account, ErrUnknownAccount)
+		case config.UnknownAccountDefault:
+			return rules.AccountMap.DefaultAccount, nil
+		}
 	}
-	
 
-	// Use Sprintf with %04d to ensure the account number is zero-padded to 4 digits.
-	resultStr := fmt.Sprintf("%04d", num)
-	newMessageCode := changeTestCode(messageCode, rules)
-	newMessage := []byte(firstPart + resultStr + newMessageCode + secondPart)
+	for _, rule := range rules.EffectiveAccountRules() {
+		if !rule.Accounts.Contains(account) {
+			continue
+		}
 
-	slog.Debug("Changed account number", "original", accountNumber, "new", resultStr)
-	return newMessage, nil
+		result := account
+		switch rule.Action {
+		case config.AccountActionAdd:
+			result = account + rule.Value
+		case config.AccountActionSubtract:
+			result = account - rule.Value
+		case config.AccountActionReplace:
+			result = rule.Value
+		case config.AccountActionPass:
+		default:
+			return 0, fmt.Errorf("unknown account action '%s'", rule.Action)
+		}
+
+		if result < 0 || result > config.MaxAccount {
+			return 0, fmt.Errorf("account %d rewritten to %d does not fit the 4-digit field", account, result)
+		}
+		return result, nil
+	}
+	return account, nil
 }
 
-func changeTestCode(code string, rules *config.CIDRules) string {
-	if newCode, ok := rules.TestCodeMap[code]; ok {
-		return newCode
+// changeTestCode applies the first matching code rule, falling back to TestCodeMap.
+func changeTestCode(msg *Message, rules *config.CIDRules) {
+	for i := range rules.CodeRules {
+		rule := &rules.CodeRules[i]
+		if !msg.Matches(&rule.Match) {
+			continue
+		}
+
+		before := msg.EventCode()
+		if rule.Set.Qualifier != "" {
+			msg.Qualifier = Qualifier(rule.Set.Qualifier[0])
+		}
+		if rule.Set.Code != nil {
+			msg.Code = *rule.Set.Code
+		}
+		if rule.Set.Zone != nil {
+			msg.Zone = *rule.Set.Zone
+		}
+		slog.Debug("Rewrote event code", "rule", i, "original", before, "new", msg.EventCode(), "zone", msg.Zone)
+		return
+	}
+
+	newCode, ok := rules.TestCodeMap[msg.EventCode()]
+	if !ok {
+		return
+	}
+	if len(newCode) != 4 {
+		slog.Warn("Ignoring malformed test code mapping", "from", msg.EventCode(), "to", newCode)
+		return
+	}
+	code, err := strconv.Atoi(newCode[1:])
+	if err != nil {
+		slog.Warn("Ignoring malformed test code mapping", "from", msg.EventCode(), "to", newCode)
+		return
 	}
-	return code
-}
\ No newline at end of file
+	msg.Qualifier = Qualifier(newCode[0])
+	msg.Code = code
+}
+
+// ApplyFilters returns the action of the first filter matching the message and
+// its index, or FilterForward and -1 when no filter matches.
+func ApplyFilters(msg *Message, rules *config.CIDRules) (string, int) {
+	for i := range rules.Filters {
+		if msg.Matches(&rules.Filters[i].Match) {
+			return rules.Filters[i].Action, i
+		}
+	}
+	return config.FilterForward, -1
+}
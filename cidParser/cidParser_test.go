@@ -2,8 +2,13 @@ package cidparser
 
 import (
 	"cid_retranslator/config"
+	"errors"
+	"os"
+	"path/filepath"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 func TestIsMessageValid(t *testing.T) {
@@ -88,7 +93,7 @@ func TestChangeAccountNumber(t *testing.T) {
 			message:       []byte("short"),
 			rules:         rules,
 			expectError:   true,
-			expectedError: "invalid message length: got 5, want at least 15",
+			expectedError: "invalid message length: got 5, want 21",
 		},
 		{
 			name:          "non-numeric account number",
@@ -119,4 +124,346 @@ func TestChangeAccountNumber(t *testing.T) {
 			}
 		})
 	}
-}
\ No newline at end of file
+}
+
+func TestParse(t *testing.T) {
+	msg, err := Parse([]byte("5040 182109E60301012\x14"))
+	if err != nil {
+		t.Fatalf("Parse() unexpected error: %v", err)
+	}
+
+	want := Message{
+		Prefix:    '5',
+		Receiver:  4,
+		Line:      0,
+		Format:    "18",
+		Account:   2109,
+		Qualifier: QualifierNew,
+		Code:      603,
+		Group:     1,
+		Zone:      12,
+	}
+	if *msg != want {
+		t.Errorf("Parse() = %+v, want %+v", *msg, want)
+	}
+	if got := msg.EventCode(); got != "E603" {
+		t.Errorf("EventCode() = %s, want E603", got)
+	}
+}
+
+func TestParse_Errors(t *testing.T) {
+	tests := []struct {
+		name          string
+		message       string
+		expectedError string
+	}{
+		{"short frame", "5040 18", "invalid message length: got 7, want 21"},
+		{"missing terminator", "5040 182109E603000000", "missing frame terminator"},
+		{"bad separator", "5040-182109E60300000\x14", "invalid separator"},
+		{"unknown format", "5040 172109E60300000\x14", "unsupported message format '17'"},
+		{"bad account", "5040 1821X9E60300000\x14", "error converting account number '21X9'"},
+		{"bad qualifier", "5040 182109X60300000\x14", "invalid event qualifier 'X'"},
+		{"bad event code", "5040 182109E6A300000\x14", "error converting event code '6A3'"},
+		{"bad zone", "5040 182109E60300-01\x14", "invalid zone '-01'"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse([]byte(tt.message))
+			if err == nil {
+				t.Fatalf("expected an error but got none")
+			}
+			if !strings.Contains(err.Error(), tt.expectedError) {
+				t.Errorf("expected error containing '%s' but got '%s'", tt.expectedError, err.Error())
+			}
+		})
+	}
+}
+
+func TestMessage_EncodeRoundTrip(t *testing.T) {
+	frames := []string{
+		"5040 182109E60300000\x14",
+		"5121 980001R40102999\x14",
+		"5999 181234P13099001\x14",
+	}
+
+	for _, frame := range frames {
+		msg, err := Parse([]byte(frame))
+		if err != nil {
+			t.Fatalf("Parse(%q) unexpected error: %v", frame, err)
+		}
+		encoded, err := msg.Encode()
+		if err != nil {
+			t.Fatalf("Encode() unexpected error: %v", err)
+		}
+		if string(encoded) != frame {
+			t.Errorf("Encode() = %q, want %q", encoded, frame)
+		}
+	}
+}
+
+func TestMessage_EncodeOverflow(t *testing.T) {
+	msg, _ := Parse([]byte("5040 182109E60300000\x14"))
+	msg.Account = 10000
+
+	if _, err := msg.Encode(); err == nil || !strings.Contains(err.Error(), "account number 10000 does not fit") {
+		t.Errorf("Encode() error = %v, want account overflow error", err)
+	}
+}
+
+func TestChangeAccountNumber_AccountRules(t *testing.T) {
+	rules := &config.CIDRules{
+		AccountRules: []config.AccountRule{
+			{Accounts: config.Range{Min: 100, Max: 100, Set: true}, Action: config.AccountActionPass},
+			{Accounts: config.Range{Min: 1, Max: 500, Set: true}, Action: config.AccountActionAdd, Value: 5000},
+			{Accounts: config.Range{Min: 7000, Max: 7999, Set: true}, Action: config.AccountActionSubtract, Value: 1000},
+			{Accounts: config.Range{Min: 9000, Max: 9000, Set: true}, Action: config.AccountActionReplace, Value: 42},
+			{Accounts: config.Range{Min: 9500, Max: 9999, Set: true}, Action: config.AccountActionAdd, Value: 100},
+		},
+	}
+
+	tests := []struct {
+		name        string
+		message     string
+		expected    string
+		expectError bool
+	}{
+		{"exact pass wins over later range", "5040 180100E60300000\x14", "5040 180100E60300000\x14", false},
+		{"range add", "5040 180200E60300000\x14", "5040 185200E60300000\x14", false},
+		{"range subtract", "5040 187123E13000000\x14", "5040 186123E13000000\x14", false},
+		{"exact replace", "5040 189000E13000000\x14", "5040 180042E13000000\x14", false},
+		{"no rule matches", "5040 182109E60300000\x14", "5040 182109E60300000\x14", false},
+		{"rewritten account overflows", "5040 189950E13000000\x14", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := ChangeAccountNumber([]byte(tt.message), rules)
+			if tt.expectError {
+				if err == nil {
+					t.Errorf("expected an error but got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("did not expect an error but got: %v", err)
+			}
+			if string(result) != tt.expected {
+				t.Errorf("expected '%s' but got '%s'", tt.expected, string(result))
+			}
+		})
+	}
+}
+
+func TestChangeAccountNumber_AccountMap(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "accounts.csv")
+	if err := os.WriteFile(path, []byte("old,new\n# comment\n2109,0301\n0042,9001\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	rules := &config.CIDRules{
+		AccNumAdd:  2100,
+		AccountMap: config.AccountMapConfig{File: path, Unknown: config.UnknownAccountPass},
+	}
+
+	tests := []struct {
+		name     string
+		message  string
+		unknown  string
+		expected string
+		wantErr  error
+	}{
+		{"mapped account wins over range rule", "5040 182109E13000000\x14", config.UnknownAccountPass, "5040 180301E13000000\x14", nil},
+		{"unknown account falls through to range rules", "5040 182110E13000000\x14", config.UnknownAccountPass, "5040 184210E13000000\x14", nil},
+		{"unknown account routed to default", "5040 181111E13000000\x14", config.UnknownAccountDefault, "5040 180777E13000000\x14", nil},
+		{"unknown account rejected", "5040 181111E13000000\x14", config.UnknownAccountReject, "", ErrUnknownAccount},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rules.AccountMap.Unknown = tt.unknown
+			rules.AccountMap.DefaultAccount = 777
+
+			result, err := ChangeAccountNumber([]byte(tt.message), rules)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Errorf("expected error %v, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("did not expect an error but got: %v", err)
+			}
+			if string(result) != tt.expected {
+				t.Errorf("expected '%s' but got '%s'", tt.expected, string(result))
+			}
+		})
+	}
+}
+
+func TestAccountTable_Reload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "accounts.csv")
+	if err := os.WriteFile(path, []byte("1,2\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	table, err := accountTableFor(&config.AccountMapConfig{File: path, ReloadInterval: time.Nanosecond})
+	if err != nil {
+		t.Fatalf("accountTableFor() error: %v", err)
+	}
+	if got, ok := table.Lookup(1); !ok || got != 2 {
+		t.Fatalf("Lookup(1) = %d, %v; want 2, true", got, ok)
+	}
+
+	if err := os.WriteFile(path, []byte("1,3\n5,6\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if got, ok := table.Lookup(1); !ok || got != 3 {
+		t.Errorf("Lookup(1) after reload = %d, %v; want 3, true", got, ok)
+	}
+	if table.Len() != 2 {
+		t.Errorf("Len() = %d, want 2", table.Len())
+	}
+
+	// A broken file keeps the previous table.
+	if err := os.WriteFile(path, []byte("1,x\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if got, ok := table.Lookup(5); !ok || got != 6 {
+		t.Errorf("Lookup(5) after broken reload = %d, %v; want 6, true", got, ok)
+	}
+}
+
+func TestChangeAccountNumber_AccountMapUnavailable(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "late.csv")
+	rules := &config.CIDRules{
+		AccountMap: config.AccountMapConfig{File: path, Unknown: config.UnknownAccountDefault, DefaultAccount: 777, ReloadInterval: time.Nanosecond},
+	}
+
+	if err := LoadAccountMap(&rules.AccountMap); err == nil {
+		t.Fatal("LoadAccountMap() expected error for missing file")
+	}
+	// The unknown-account policy applies while the file is missing.
+	result, err := ChangeAccountNumber([]byte("5040 181111E13000000\x14"), rules)
+	if err != nil || string(result) != "5040 180777E13000000\x14" {
+		t.Fatalf("ChangeAccountNumber() = %q, %v; want default account", result, err)
+	}
+
+	// The file is picked up once it appears.
+	if err := os.WriteFile(path, []byte("1111,2222\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	result, err = ChangeAccountNumber([]byte("5040 181111E13000000\x14"), rules)
+	if err != nil || string(result) != "5040 182222E13000000\x14" {
+		t.Errorf("ChangeAccountNumber() = %q, %v; want mapped account", result, err)
+	}
+}
+
+func TestAccountTable_ConcurrentRefresh(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "accounts.csv")
+	if err := os.WriteFile(path, []byte("1,2\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	table, err := accountTableFor(&config.AccountMapConfig{File: path, ReloadInterval: time.Nanosecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 200; j++ {
+				if _, ok := table.Lookup(1); !ok {
+					t.Error("Lookup(1) missed during refresh")
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestChangeAccountNumber_CodeRules(t *testing.T) {
+	code602, code130, zone0 := 602, 130, 0
+	rules := &config.CIDRules{
+		AccountRules: []config.AccountRule{{Action: config.AccountActionPass}},
+		TestCodeMap:  map[string]string{"E603": "E602"},
+		CodeRules: []config.CodeRule{
+			{
+				// Vendor A reports periodic tests as R603 on zone 999.
+				Match: config.MessageMatch{
+					Accounts:  config.Range{Min: 1000, Max: 1999, Set: true},
+					Codes:     config.Range{Min: 603, Max: 603, Set: true},
+					Qualifier: "R",
+				},
+				Set: config.CodeRewrite{Qualifier: "E", Code: &code602, Zone: &zone0},
+			},
+			{
+				// Zone 5 in partition 2 is a burglary sensor reported as a generic alarm.
+				Match: config.MessageMatch{
+					Codes:  config.Range{Min: 140, Max: 140, Set: true},
+					Groups: config.Range{Min: 2, Max: 2, Set: true},
+					Zones:  config.Range{Min: 5, Max: 5, Set: true},
+				},
+				Set: config.CodeRewrite{Code: &code130},
+			},
+		},
+	}
+
+	tests := []struct {
+		name     string
+		message  string
+		expected string
+	}{
+		{"scoped rewrite of qualifier, code and zone", "5040 181500R60300999\x14", "5040 181500E60200000\x14"},
+		{"out of account scope uses test code map", "5040 182500R60300999\x14", "5040 182500R60300999\x14"},
+		{"zone and partition scope", "5040 182500E14002005\x14", "5040 182500E13002005\x14"},
+		{"other zone untouched", "5040 182500E14002006\x14", "5040 182500E14002006\x14"},
+		{"legacy test code map still applies", "5040 182500E60300000\x14", "5040 182500E60200000\x14"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := ChangeAccountNumber([]byte(tt.message), rules)
+			if err != nil {
+				t.Fatalf("did not expect an error but got: %v", err)
+			}
+			if string(result) != tt.expected {
+				t.Errorf("expected '%s' but got '%s'", tt.expected, string(result))
+			}
+		})
+	}
+}
+
+func TestApplyFilters(t *testing.T) {
+	rules := &config.CIDRules{
+		Filters: []config.FilterRule{
+			{Name: "periodic tests", Match: config.MessageMatch{Codes: config.Range{Min: 602, Max: 603, Set: true}}, Action: config.FilterDropACK},
+			{Name: "decommissioned", Match: config.MessageMatch{Accounts: config.Range{Min: 3000, Max: 3099, Set: true}}, Action: config.FilterDropNACK},
+			{Name: "restores of 3050", Match: config.MessageMatch{Accounts: config.Range{Min: 3050, Max: 3050, Set: true}, Qualifier: "R"}, Action: config.FilterForward},
+		},
+	}
+
+	tests := []struct {
+		message    string
+		wantAction string
+		wantRule   int
+	}{
+		{"5040 182109E60300000\x14", config.FilterDropACK, 0},
+		{"5040 183050R13000000\x14", config.FilterDropNACK, 1},
+		{"5040 182109E13000000\x14", config.FilterForward, -1},
+	}
+
+	for _, tt := range tests {
+		msg, err := Parse([]byte(tt.message))
+		if err != nil {
+			t.Fatalf("Parse(%q) error: %v", tt.message, err)
+		}
+		action, rule := ApplyFilters(msg, rules)
+		if action != tt.wantAction || rule != tt.wantRule {
+			t.Errorf("ApplyFilters(%q) = %s, %d; want %s, %d", tt.message, action, rule, tt.wantAction, tt.wantRule)
+		}
+	}
+}
@@ -0,0 +1,156 @@
+package cidparser
+
+import (
+	"cid_retranslator/config"
+	"fmt"
+	"strconv"
+)
+
+// Surgard-style Contact ID frame layout:
+//
+//	5 RR L _ 18 AAAA Q EEE GG CCC <DC4>
+//
+// prefix, receiver, line, space, format, account, qualifier, event code,
+// group (partition), zone (user) and the 0x14 terminator.
+const (
+	FrameLength = 21
+	Terminator  = 0x14
+)
+
+// Qualifier is the Contact ID event qualifier.
+type Qualifier byte
+
+const (
+	QualifierNew      Qualifier = 'E' // new event or opening
+	QualifierRestore  Qualifier = 'R' // restore or closing
+	QualifierPrevious Qualifier = 'P' // previously reported condition still present
+)
+
+func (q Qualifier) String() string {
+	return string(q)
+}
+
+// Message is a parsed Surgard-style Contact ID frame.
+type Message struct {
+	Prefix    byte      `json:"prefix"`
+	Receiver  int       `json:"receiver"`
+	Line      int       `json:"line"`
+	Format    string    `json:"format"`
+	Account   int       `json:"account"`
+	Qualifier Qualifier `json:"qualifier"`
+	Code      int       `json:"code"`
+	Group     int       `json:"group"`
+	Zone      int       `json:"zone"`
+}
+
+// Parse decodes a 0x14-terminated Contact ID frame.
+func Parse(frame []byte) (*Message, error) {
+	if len(frame) != FrameLength {
+		return nil, fmt.Errorf("invalid message length: got %d, want %d", len(frame), FrameLength)
+	}
+	if frame[FrameLength-1] != Terminator {
+		return nil, fmt.Errorf("missing frame terminator: got 0x%02x, want 0x%02x", frame[FrameLength-1], Terminator)
+	}
+	if frame[4] != ' ' {
+		return nil, fmt.Errorf("invalid separator at offset 4: %q", frame[4])
+	}
+
+	s := string(frame)
+	m := &Message{Prefix: frame[0], Format: s[5:7]}
+	if m.Format != "18" && m.Format != "98" {
+		return nil, fmt.Errorf("unsupported message format '%s'", m.Format)
+	}
+
+	var err error
+	if m.Receiver, err = parseField("receiver number", s[1:3]); err != nil {
+		return nil, err
+	}
+	if m.Line, err = parseField("line number", s[3:4]); err != nil {
+		return nil, err
+	}
+	if m.Account, err = parseField("account number", s[7:11]); err != nil {
+		return nil, err
+	}
+
+	m.Qualifier = Qualifier(frame[11])
+	switch m.Qualifier {
+	case QualifierNew, QualifierRestore, QualifierPrevious:
+	default:
+		return nil, fmt.Errorf("invalid event qualifier %q", frame[11])
+	}
+
+	if m.Code, err = parseField("event code", s[12:15]); err != nil {
+		return nil, err
+	}
+	if m.Group, err = parseField("group", s[15:17]); err != nil {
+		return nil, err
+	}
+	if m.Zone, err = parseField("zone", s[17:20]); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Encode renders the message back into a 0x14-terminated frame.
+func (m *Message) Encode() ([]byte, error) {
+	if err := checkField("receiver number", m.Receiver, 99); err != nil {
+		return nil, err
+	}
+	if err := checkField("line number", m.Line, 9); err != nil {
+		return nil, err
+	}
+	if err := checkField("account number", m.Account, 9999); err != nil {
+		return nil, err
+	}
+	if err := checkField("event code", m.Code, 999); err != nil {
+		return nil, err
+	}
+	if err := checkField("group", m.Group, 99); err != nil {
+		return nil, err
+	}
+	if err := checkField("zone", m.Zone, 999); err != nil {
+		return nil, err
+	}
+	if len(m.Format) != 2 {
+		return nil, fmt.Errorf("invalid message format '%s'", m.Format)
+	}
+
+	frame := fmt.Sprintf("%c%02d%d %s%04d%c%03d%02d%03d%c",
+		m.Prefix, m.Receiver, m.Line, m.Format, m.Account,
+		m.Qualifier, m.Code, m.Group, m.Zone, Terminator)
+	return []byte(frame), nil
+}
+
+// EventCode returns the qualifier and event code as they appear on the wire, e.g. "E603".
+func (m *Message) EventCode() string {
+	return fmt.Sprintf("%c%03d", m.Qualifier, m.Code)
+}
+
+// Matches reports whether the message satisfies every field of the match.
+func (m *Message) Matches(match *config.MessageMatch) bool {
+	if match.Qualifier != "" && match.Qualifier != m.Qualifier.String() {
+		return false
+	}
+	return match.Accounts.Contains(m.Account) &&
+		match.Codes.Contains(m.Code) &&
+		match.Groups.Contains(m.Group) &&
+		match.Zones.Contains(m.Zone)
+}
+
+func parseField(name, value string) (int, error) {
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("error converting %s '%s': %w", name, value, err)
+	}
+	if n < 0 || value[0] == '+' {
+		return 0, fmt.Errorf("invalid %s '%s'", name, value)
+	}
+	return n, nil
+}
+
+func checkField(name string, value, max int) error {
+	if value < 0 || value > max {
+		return fmt.Errorf("%s %d does not fit the frame (0-%d)", name, value, max)
+	}
+	return nil
+}
@@ -1,9 +1,13 @@
 package client
 
 import (
+	"bufio"
 	"cid_retranslator/config"
+	"cid_retranslator/dc09"
 	"cid_retranslator/queue"
 	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
 	"log/slog"
 	"net"
@@ -12,26 +16,93 @@ import (
 )
 
 type Client struct {
-	host             string
-	port             string
-	conn             *net.TCPConn
+	targets          *targets
+	current          *target
+	conn             net.Conn
 	queue            *queue.Queue
 	reconnectInitial time.Duration
 	reconnectMax     time.Duration
+	cfg              *config.ClientConfig
+	seq              sequence
+	dc09Keys         dc09.Keyring
 	cancel           context.CancelFunc
 	stopOnce         sync.Once
+	heartbeatMu      sync.RWMutex
+	lastHeartbeat    time.Time
+	// retry holds messages waiting for another delivery attempt; they go out
+	// one at a time before anything else in the queue, also after a
+	// reconnect. Holds at most maxRetry messages. Only touched by the Run
+	// loop and the connection handler it calls.
+	retry []*delivery
+	// tlsConfig is nil without TLS; setupErr keeps a broken TLS or DC-09
+	// key setup from falling back to cleartext.
+	tlsConfig *tls.Config
+	setupErr  error
 }
 
+// delivery is a message or heartbeat on its way to the receiver.
+type delivery struct {
+	data      queue.SharedData
+	heartbeat bool
+	attempts  int       // sends so far
+	tag       int       // matches the reply, see codec
+	deadline  time.Time // for the reply, zero waits indefinitely
+}
+
+// link is the delivery state of one connection.
+type link struct {
+	conn     net.Conn
+	codec    codec
+	inflight []*delivery // in send order
+}
+
+// received is a raw reply or the error that ended the reader.
+type received struct {
+	raw []byte
+	err error
+}
+
+// maxRetry bounds the retry list. Messages beyond it are moved to the
+// dead-letter store instead of piling up in memory during an outage.
+const maxRetry = 1000
+
+// dc09ReplyTimeout bounds the wait for a DC-09 ACK/NAK/DUH when no reply
+// timeout is configured.
+const dc09ReplyTimeout = 10 * time.Second
+
 func New(cfg *config.ClientConfig, q *queue.Queue) *Client {
+	var setupErr error
+	keys, err := dc09.NewKeyring(cfg.DC09.Keys)
+	if err != nil {
+		slog.Error("Invalid DC-09 keys, the client will not connect", "error", err)
+		setupErr = fmt.Errorf("invalid DC-09 keys: %w", err)
+	}
+	var tlsConfig *tls.Config
+	if cfg.TLS.Enabled {
+		if tlsConfig, err = cfg.TLS.ClientTLS(); err != nil {
+			slog.Error("Invalid TLS settings, the client will not connect", "error", err)
+			setupErr = fmt.Errorf("invalid TLS settings: %w", err)
+		}
+	}
 	return &Client{
-		host:             cfg.Host,
-		port:             cfg.Port,
+		targets:          newTargets(cfg),
+		tlsConfig:        tlsConfig,
+		setupErr:         setupErr,
 		queue:            q,
 		reconnectInitial: cfg.ReconnectInitial,
 		reconnectMax:     cfg.ReconnectMax,
+		cfg:              cfg,
+		dc09Keys:         keys,
 	}
 }
 
+// LastHeartbeat returns the time of the last acknowledged heartbeat, zero if none.
+func (client *Client) LastHeartbeat() time.Time {
+	client.heartbeatMu.RLock()
+	defer client.heartbeatMu.RUnlock()
+	return client.lastHeartbeat
+}
+
 // GetQueueStats повертає статистику з черги
 func (client *Client) GetQueueStats() (int, int, int, time.Duration) {
     return client.queue.Stats()
@@ -40,12 +111,7 @@ func (client *Client) GetQueueStats() (int, int, int, time.Duration) {
 func (client *Client) Run(ctx context.Context) {
 	ctx, cancel := context.WithCancel(ctx)
 	client.cancel = cancel
-
-	tcpAddr, err := net.ResolveTCPAddr("tcp", client.host+":"+client.port)
-	if err != nil {
-		slog.Error("Failed to resolve TCP address", "addr", client.host+":"+client.port, "error", err)
-		return
-	}
+	client.queue.UpdateStartTime()
 
 	go func() {
 		delay := client.reconnectInitial
@@ -57,18 +123,30 @@ func (client *Client) Run(ctx context.Context) {
 			default:
 			}
 
-			conn, err := net.DialTCP("tcp", nil, tcpAddr)
+			target := client.targets.current()
+			conn, err := client.dial(ctx, target)
 			if err != nil {
+				if ctx.Err() != nil {
+					return
+				}
 				reconnectAttempts++
 				client.queue.IncrementReconnects()
+				client.unreachable(err)
+				if client.targets.failed(target, err) {
+					// Try the next target right away.
+					delay = client.reconnectInitial
+					continue
+				}
 				logMessage := fmt.Sprintf("Dial failed (attempt %d), retrying in %s", reconnectAttempts, delay)
 				if reconnectAttempts > 10 { // After 10 attempts, log as a warning
-					slog.Warn(logMessage, "target", tcpAddr, "error", err)
+					slog.Warn(logMessage, "target", target.address, "error", err)
 				} else {
-					slog.Error(logMessage, "target", tcpAddr, "error", err)
+					slog.Error(logMessage, "target", target.address, "error", err)
 				}
 
-				time.Sleep(delay)
+				if !sleep(ctx, delay) {
+					return
+				}
 				delay *= 2
 				if delay > client.reconnectMax {
 					delay = client.reconnectMax
@@ -76,15 +154,25 @@ func (client *Client) Run(ctx context.Context) {
 				continue
 			}
 
-			slog.Info("Connected to target", "target", tcpAddr)
+			slog.Info("Connected to target", "target", target.address)
 			reconnectAttempts = 0 // Reset on successful connection
 			client.conn = conn
+			client.current = target
+			client.targets.setConnected(target, true)
 
-			// handleConnection blocks until connection is lost or shutdown
-			client.handleConnection(ctx, conn)
+			// handleConnection blocks until connection is lost, shutdown or
+			// fail back to the primary
+			connCtx, connCancel := context.WithCancel(ctx)
+			client.targets.watchPrimary(connCtx, target, connCancel)
+			err = client.handleConnection(connCtx, conn)
+			connCancel()
 
 			conn.Close()
 			client.conn = nil
+			client.targets.setConnected(target, false)
+			if err != nil {
+				client.targets.failed(target, err)
+			}
 			delay = client.reconnectInitial
 			slog.Info("Connection closed, reconnecting...")
 		}
@@ -107,46 +195,357 @@ func (client *Client) Stop() {
 	})
 }
 
-func (client *Client) handleConnection(ctx context.Context, conn *net.TCPConn) {
+// handleConnection delivers queued messages until the connection fails, which
+// is returned, or ctx ends. Up to Window messages are sent before waiting for
+// a reply; replies are read on their own goroutine and matched to the
+// messages in flight.
+func (client *Client) handleConnection(ctx context.Context, conn net.Conn) error {
+	l := &link{conn: conn, codec: newCodec(client.cfg, &client.seq, client.dc09Keys)}
+
+	replies := make(chan received)
+	stop := make(chan struct{})
+	defer close(stop)
+	go readReplies(conn, l.codec, replies, stop)
+
+	window := client.window()
+	interval := client.cfg.Heartbeat.Interval
+	done := ctx.Done()
 	for {
-		select {
-		case data, ok := <-client.queue.DataChannel:
-			if !ok {
-				slog.Info("DataChannel closed, stopping connection handler.")
-				return
-			}
+		if done == nil && len(l.inflight) == 0 {
+			// Messages in flight were answered after ctx ended.
+			return nil
+		}
 
-			_, err := conn.Write(data.Payload)
-			if err != nil {
-				slog.Error("Write to server failed", "error", err)
-				// Don't close the reply channel, server will timeout
-				return // Exit to reconnect
+		// A message being retried goes first, on its own.
+		if done != nil && len(l.inflight) == 0 && len(client.retry) > 0 {
+			d := client.retry[0]
+			if !sleep(ctx, client.backoff(d.attempts)) {
+				return nil
+			}
+			client.retry = client.retry[1:]
+			if err := client.send(l, d); err != nil {
+				return client.lost(l, fmt.Errorf("write to server failed: %w", err))
 			}
-			slog.Debug("Wrote to server", "data", string(data.Payload))
+			if len(l.inflight) == 0 {
+				continue
+			}
+		}
 
-			reply := make([]byte, 1024)
-			n, err := conn.Read(reply)
+		// Fill the window from the queue.
+		var ready <-chan struct{}
+		for done != nil && len(client.retry) == 0 && len(l.inflight) < window {
+			data, err := client.queue.TryPop()
+			if errors.Is(err, queue.ErrEmpty) {
+				ready = client.queue.Ready()
+				break
+			}
 			if err != nil {
-				slog.Error("Read from server failed", "error", err)
-				// Don't close the reply channel, server will timeout
-				return // Exit to reconnect
+				slog.Info("Queue closed, stopping connection handler.")
+				client.retryLater(l, err)
+				return nil
 			}
+			if err := client.send(l, &delivery{data: data, attempts: data.Attempts}); err != nil {
+				return client.lost(l, fmt.Errorf("write to server failed: %w", err))
+			}
+		}
+
+		// A heartbeat is only sent after Interval without traffic.
+		var idle <-chan time.Time
+		var timer *time.Timer
+		if done != nil && interval > 0 && len(l.inflight) == 0 {
+			timer = time.NewTimer(interval)
+			idle = timer.C
+		}
 
-			slog.Debug("Reply from server", "reply", string(reply[:n]))
-			if n == 1 && reply[0] == 0x06 {
-				slog.Info("Received ACK")
-				data.ReplyCh <- queue.DeliveryData{Status: true}
-				client.queue.IncrementAccepted()
-			} else {
-				slog.Warn("Received NACK or other non-ACK response")
-				data.ReplyCh <- queue.DeliveryData{Status: false}
-				client.queue.IncrementRejected()
+		select {
+		case <-done:
+			// Wait for the replies to what was sent, so a fail-back does not
+			// deliver them twice.
+			slog.Info("Stopping connection handler.")
+			done = nil
+		case <-ready:
+		case <-idle:
+			if err := client.send(l, &delivery{heartbeat: true}); err != nil {
+				return client.lost(l, fmt.Errorf("heartbeat failed: %w", err))
+			}
+		case r := <-replies:
+			if r.err != nil {
+				return client.lost(l, fmt.Errorf("read from server failed: %w", r.err))
 			}
-			close(data.ReplyCh)
+			if err := client.handleReply(l, r.raw); err != nil {
+				return client.lost(l, err)
+			}
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+	}
+}
 
-		case <-ctx.Done():
-			slog.Info("Stopping connection handler due to shutdown signal.")
+// readReplies reads replies from conn until it fails or stop is closed.
+func readReplies(conn net.Conn, codec codec, replies chan<- received, stop <-chan struct{}) {
+	reader := bufio.NewReader(conn)
+	for {
+		raw, err := codec.readReply(reader)
+		select {
+		case replies <- received{raw: raw, err: err}:
+		case <-stop:
+			return
+		}
+		if err != nil {
 			return
 		}
 	}
-}
\ No newline at end of file
+}
+
+// lost logs the error that ended the connection and keeps the messages in
+// flight for the next one.
+func (client *Client) lost(l *link, err error) error {
+	slog.Error("Delivery failed, reconnecting", "error", err, "inflight", len(l.inflight))
+	client.retryLater(l, err)
+	return err
+}
+
+// send writes a message or heartbeat and adds it to the messages in flight.
+// It returns an error only when the connection is unusable.
+func (client *Client) send(l *link, d *delivery) error {
+	var frame []byte
+	var err error
+	timeout := client.messageTimeout()
+	if d.heartbeat {
+		frame, d.tag, err = l.codec.heartbeat()
+		timeout = client.cfg.Heartbeat.Timeout
+	} else {
+		frame, d.tag, err = l.codec.encode(d.data.Payload)
+		if err != nil {
+			slog.Error("Cannot encode message", "error", err, "data", string(d.data.Payload))
+			client.queue.IncrementRejected()
+			client.fail(d, queue.DeliveryData{Status: false}, "cannot encode: "+err.Error())
+			return nil
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	if !d.heartbeat {
+		// A failed write counts as well, so a broken link cannot keep a
+		// waiting sender from its answer.
+		d.attempts++
+	}
+	if _, err := l.conn.Write(frame); err != nil {
+		// Not sent, but kept in order with the others for retryLater.
+		l.inflight = append(l.inflight, d)
+		return err
+	}
+	d.deadline = time.Time{}
+	if timeout > 0 {
+		d.deadline = time.Now().Add(timeout)
+	}
+	if !d.heartbeat {
+		slog.Debug("Wrote to server", "data", string(d.data.Payload), "attempt", d.attempts, "inflight", len(l.inflight)+1)
+	}
+	l.inflight = append(l.inflight, d)
+	return l.setDeadline()
+}
+
+// setDeadline gives the reader until the reply deadline of the oldest message
+// in flight.
+func (l *link) setDeadline() error {
+	if len(l.inflight) == 0 {
+		return l.conn.SetReadDeadline(time.Time{})
+	}
+	return l.conn.SetReadDeadline(l.inflight[0].deadline)
+}
+
+// handleReply settles the message a reply answers. It returns an error only
+// when the connection is unusable.
+func (client *Client) handleReply(l *link, raw []byte) error {
+	reply, tag, ok := l.codec.decode(raw)
+	if !ok {
+		return nil
+	}
+
+	i := -1
+	for j, d := range l.inflight {
+		if tag == noTag || d.tag == tag {
+			i = j
+			break
+		}
+	}
+	if i < 0 {
+		slog.Warn("Ignoring reply that matches no message in flight", "tag", tag, "response", reply.Response)
+		return nil
+	}
+	d := l.inflight[i]
+	l.inflight = append(l.inflight[:i], l.inflight[i+1:]...)
+	l.codec.done(d.tag)
+	if err := l.setDeadline(); err != nil {
+		return err
+	}
+	client.targets.ok(client.current)
+
+	if d.heartbeat {
+		if !reply.Status {
+			return fmt.Errorf("heartbeat not acknowledged: %s", reply.Response)
+		}
+		client.heartbeatMu.Lock()
+		client.lastHeartbeat = time.Now()
+		client.heartbeatMu.Unlock()
+		slog.Debug("Heartbeat acknowledged")
+		return nil
+	}
+
+	if reply.Status {
+		slog.Info("Received ACK")
+		client.queue.IncrementAccepted()
+		client.settle(d, reply)
+		return nil
+	}
+
+	slog.Warn("Received NACK or other non-ACK response", "response", reply.Response)
+	client.queue.IncrementRejected()
+	if d.attempts < client.maxAttempts() {
+		slog.Warn("Retrying message", "attempt", d.attempts+1, "data", string(d.data.Payload))
+		client.retry = append(client.retry, d)
+		client.trimRetry()
+		return nil
+	}
+	client.fail(d, reply, "rejected by receiver: "+reply.Response)
+	return nil
+}
+
+// settle completes the message in the queue and answers a waiting sender.
+func (client *Client) settle(d *delivery, reply queue.DeliveryData) {
+	client.queue.Complete(d.data)
+	d.data.Reply(reply)
+}
+
+// fail moves the message to the dead-letter store and answers a waiting
+// sender.
+func (client *Client) fail(d *delivery, reply queue.DeliveryData, reason string) {
+	slog.Error("Message moved to dead-letter store", "reason", reason, "attempts", d.attempts, "data", string(d.data.Payload))
+	client.queue.DeadLetter(d.data, reason, d.attempts)
+	d.data.Reply(reply)
+}
+
+// retryLater keeps the messages whose delivery was cut off for the next
+// connection, ahead of earlier retries. A waiting sender is told about the
+// failure once all attempts are used; stored messages wait for the receiver
+// however long it takes.
+func (client *Client) retryLater(l *link, err error) {
+	var keep []*delivery
+	for _, d := range l.inflight {
+		switch {
+		case d.heartbeat:
+		case d.data.ReplyCh != nil && d.attempts >= client.maxAttempts():
+			client.fail(d, queue.DeliveryData{Status: false}, "no reply: "+err.Error())
+		default:
+			keep = append(keep, d)
+		}
+	}
+	l.inflight = nil
+	client.retry = append(keep, client.retry...)
+	client.trimRetry()
+}
+
+// unreachable counts a failed dial as an attempt for every message whose
+// sender is waiting, including those still in the queue, and fails the ones
+// out of attempts, so the sender gets a prompt NACK rather than its own
+// timeout. Queued messages stay in their lanes. Stored messages keep waiting
+// for the receiver.
+func (client *Client) unreachable(err error) {
+	reason := "receiver unreachable: " + err.Error()
+	keep := make([]*delivery, 0, len(client.retry))
+	for _, d := range client.retry {
+		if d.data.ReplyCh != nil {
+			d.attempts++
+			if d.attempts >= client.maxAttempts() {
+				client.fail(d, queue.DeliveryData{Status: false}, reason)
+				continue
+			}
+		}
+		keep = append(keep, d)
+	}
+	client.retry = keep
+
+	exhausted := client.queue.Sweep(func(data *queue.SharedData) bool {
+		if data.ReplyCh == nil {
+			return true
+		}
+		data.Attempts++
+		return data.Attempts < client.maxAttempts()
+	})
+	for _, data := range exhausted {
+		client.fail(&delivery{data: data, attempts: data.Attempts}, queue.DeliveryData{Status: false}, reason)
+	}
+}
+
+// trimRetry moves the messages beyond maxRetry to the dead-letter store.
+func (client *Client) trimRetry() {
+	if len(client.retry) <= maxRetry {
+		return
+	}
+	for _, d := range client.retry[maxRetry:] {
+		client.fail(d, queue.DeliveryData{Status: false}, "retry list full")
+	}
+	client.retry = client.retry[:maxRetry]
+}
+
+// maxAttempts is the number of sends per message.
+func (client *Client) maxAttempts() int {
+	if client.cfg.Retry.Attempts > 0 {
+		return client.cfg.Retry.Attempts
+	}
+	return 1
+}
+
+// window is the number of messages that may await a reply at once.
+func (client *Client) window() int {
+	if client.cfg.Window > 0 {
+		return client.cfg.Window
+	}
+	return 1
+}
+
+// backoff is the delay before re-sending a message sent attempts times. It
+// doubles with every attempt, starting at Retry.Backoff.
+func (client *Client) backoff(attempts int) time.Duration {
+	delay := client.cfg.Retry.Backoff
+	if delay <= 0 || attempts == 0 {
+		return 0
+	}
+	for i := 1; i < attempts; i++ {
+		delay *= 2
+		if client.cfg.Retry.BackoffMax > 0 && delay >= client.cfg.Retry.BackoffMax {
+			return client.cfg.Retry.BackoffMax
+		}
+	}
+	return delay
+}
+
+// sleep waits for d and reports false if ctx ended first.
+func sleep(ctx context.Context, d time.Duration) bool {
+	if d <= 0 {
+		return ctx.Err() == nil
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// messageTimeout is the reply deadline for alarm messages.
+func (client *Client) messageTimeout() time.Duration {
+	if client.cfg.ReplyTimeout > 0 {
+		return client.cfg.ReplyTimeout
+	}
+	if client.cfg.Protocol == config.ProtocolDC09 {
+		return dc09ReplyTimeout
+	}
+	return 0
+}
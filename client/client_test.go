@@ -0,0 +1,356 @@
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"cid_retranslator/config"
+	"cid_retranslator/dc09"
+	"cid_retranslator/queue"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+const testMessage = "5000 181234E13000001\x14"
+
+// closedPort returns the address of a port nothing listens on.
+func closedPort(t *testing.T) (host, port string) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+	host, port, _ = net.SplitHostPort(addr)
+	return host, port
+}
+
+// startClient runs a client on q until the test ends.
+func startClient(t *testing.T, cfg *config.ClientConfig, q *queue.Queue) *Client {
+	t.Helper()
+	c := New(cfg, q)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		c.Run(ctx)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		c.Stop()
+		<-done
+	})
+	return c
+}
+
+// awaitReply waits for the result of a dispatched message.
+func awaitReply(t *testing.T, replyCh <-chan queue.DeliveryData, within time.Duration) queue.DeliveryData {
+	t.Helper()
+	select {
+	case reply := <-replyCh:
+		return reply
+	case <-time.After(within):
+		t.Fatalf("no reply within %s", within)
+		return queue.DeliveryData{}
+	}
+}
+
+func TestClient_UnreachableFailsWaitingSender(t *testing.T) {
+	host, port := closedPort(t)
+	cfg := &config.ClientConfig{
+		Host:             host,
+		Port:             port,
+		ReconnectInitial: 10 * time.Millisecond,
+		ReconnectMax:     20 * time.Millisecond,
+		Retry:            config.RetryConfig{Attempts: 3},
+	}
+	q := queue.New(10)
+	startClient(t, cfg, q)
+
+	waiting, err := q.Dispatch([]byte(testMessage), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored, err := q.Dispatch([]byte(testMessage), false)
+	if err != nil || stored != nil {
+		t.Fatalf("Dispatch() = %v, %v", stored, err)
+	}
+
+	// Three failed dials, well before any server reply timeout.
+	if reply := awaitReply(t, waiting, 2*time.Second); reply.Status {
+		t.Errorf("reply = %+v, want NACK", reply)
+	}
+	letters := q.DeadLetters()
+	if len(letters) != 1 || letters[0].Attempts != 3 {
+		t.Errorf("dead letters = %+v, want one after 3 attempts", letters)
+	}
+	// The stored message stays in its lane.
+	if stats := q.LaneStats(); stats[0].Depth != 1 || stats[0].Popped != 0 {
+		t.Errorf("LaneStats() = %+v, want the stored message still queued", stats)
+	}
+}
+
+func TestClient_RetryLimit(t *testing.T) {
+	c := New(&config.ClientConfig{}, queue.New(10))
+	for i := 0; i < maxRetry+5; i++ {
+		c.retry = append(c.retry, &delivery{data: queue.SharedData{Payload: []byte(testMessage)}})
+	}
+	c.trimRetry()
+	if len(c.retry) != maxRetry {
+		t.Errorf("retry list = %d, want %d", len(c.retry), maxRetry)
+	}
+	if n := c.queue.DeadLetterCount(); n != 5 {
+		t.Errorf("dead letters = %d, want 5", n)
+	}
+}
+
+func TestClient_BadDC09KeyRefusesToConnect(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	host, port, _ := net.SplitHostPort(ln.Addr().String())
+
+	cfg := &config.ClientConfig{
+		Host:     host,
+		Port:     port,
+		Protocol: config.ProtocolDC09,
+		DC09:     config.DC09ClientConfig{Keys: []config.DC09Key{{Account: "1234", Key: "00"}}},
+	}
+	c := New(cfg, queue.New(1))
+	if _, err := c.dial(context.Background(), c.targets.current()); err == nil {
+		t.Fatal("dial() succeeded with an invalid key, messages would go out in cleartext")
+	}
+}
+
+// receiver accepts connections on addr and serves each with handle until the
+// test ends. It returns the address it listens on.
+func receiver(t *testing.T, addr string, handle func(net.Conn)) (host, port string) {
+	t.Helper()
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var conns []net.Conn
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			mu.Lock()
+			conns = append(conns, conn)
+			mu.Unlock()
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				defer conn.Close()
+				handle(conn)
+			}()
+		}
+	}()
+	t.Cleanup(func() {
+		ln.Close()
+		mu.Lock()
+		for _, conn := range conns {
+			conn.Close()
+		}
+		mu.Unlock()
+		wg.Wait()
+	})
+
+	host, port, _ = net.SplitHostPort(ln.Addr().String())
+	return host, port
+}
+
+// acker answers every Surgard frame with ACK and reports it on got.
+func acker(got chan<- string) func(net.Conn) {
+	return func(conn net.Conn) {
+		reader := bufio.NewReader(conn)
+		for {
+			frame, err := reader.ReadBytes(0x14)
+			if err != nil {
+				return
+			}
+			got <- string(frame)
+			if _, err := conn.Write([]byte{0x06}); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func TestClient_Pipelining(t *testing.T) {
+	tests := []struct {
+		window    int
+		wantBatch int
+	}{
+		{0, 1},
+		{1, 1},
+		{3, 3},
+		{5, 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("window %d", tt.window), func(t *testing.T) {
+			// The receiver reads until the client stops sending and then
+			// ACKs everything it got, in order.
+			batches := make(chan int, 10)
+			host, port := receiver(t, "127.0.0.1:0", func(conn net.Conn) {
+				reader := bufio.NewReader(conn)
+				for {
+					n := 0
+					for {
+						conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+						if _, err := reader.ReadBytes(0x14); err != nil {
+							if errors.Is(err, os.ErrDeadlineExceeded) {
+								break
+							}
+							return
+						}
+						n++
+					}
+					if n == 0 {
+						continue
+					}
+					batches <- n
+					if _, err := conn.Write(bytes.Repeat([]byte{0x06}, n)); err != nil {
+						return
+					}
+				}
+			})
+
+			q := queue.New(10)
+			var waiting []<-chan queue.DeliveryData
+			for i := 0; i < 3; i++ {
+				replyCh, err := q.Dispatch([]byte(testMessage), true)
+				if err != nil {
+					t.Fatal(err)
+				}
+				waiting = append(waiting, replyCh)
+			}
+			startClient(t, &config.ClientConfig{Host: host, Port: port, Window: tt.window}, q)
+
+			select {
+			case n := <-batches:
+				if n != tt.wantBatch {
+					t.Errorf("sent %d messages before the first reply, want %d", n, tt.wantBatch)
+				}
+			case <-time.After(2 * time.Second):
+				t.Fatal("nothing received")
+			}
+			for i, replyCh := range waiting {
+				if reply := awaitReply(t, replyCh, 2*time.Second); !reply.Status {
+					t.Errorf("message %d reply = %+v, want ACK", i+1, reply)
+				}
+			}
+		})
+	}
+}
+
+func TestClient_PipeliningMatchesDC09Replies(t *testing.T) {
+	// The receiver answers two messages in reverse order: the second with
+	// ACK, the first with DUH.
+	host, port := receiver(t, "127.0.0.1:0", func(conn net.Conn) {
+		reader := bufio.NewReader(conn)
+		var frames []*dc09.Frame
+		for len(frames) < 2 {
+			raw, err := dc09.ReadFrame(reader)
+			if err != nil {
+				return
+			}
+			frame, err := dc09.Decode(raw)
+			if err != nil {
+				t.Errorf("Decode() error: %v", err)
+				return
+			}
+			frames = append(frames, frame)
+		}
+		conn.Write(frames[1].Reply(dc09.IDACK).Encode())
+		conn.Write(frames[0].Reply(dc09.IDDUH).Encode())
+		io.Copy(io.Discard, conn)
+	})
+
+	q := queue.New(10)
+	first, _ := q.Dispatch([]byte("5000 181234E13000001\x14"), true)
+	second, _ := q.Dispatch([]byte("5000 181235E13000001\x14"), true)
+	startClient(t, &config.ClientConfig{Host: host, Port: port, Protocol: config.ProtocolDC09, Window: 2}, q)
+
+	if reply := awaitReply(t, second, 2*time.Second); !reply.Status {
+		t.Errorf("second message reply = %+v, want ACK", reply)
+	}
+	if reply := awaitReply(t, first, 2*time.Second); reply.Status || reply.Response != dc09.IDDUH {
+		t.Errorf("first message reply = %+v, want DUH", reply)
+	}
+}
+
+func TestClient_Heartbeat(t *testing.T) {
+	const frame = "1011           @    "
+
+	t.Run("acknowledged", func(t *testing.T) {
+		got := make(chan string, 10)
+		host, port := receiver(t, "127.0.0.1:0", acker(got))
+		cfg := &config.ClientConfig{
+			Host:      host,
+			Port:      port,
+			Heartbeat: config.HeartbeatConfig{Interval: 20 * time.Millisecond, Timeout: time.Second, Frame: frame},
+		}
+		c := startClient(t, cfg, queue.New(10))
+
+		select {
+		case f := <-got:
+			if f != frame+"\x14" {
+				t.Errorf("received %q, want the heartbeat frame", f)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatal("no heartbeat sent on an idle connection")
+		}
+		for start := time.Now(); c.LastHeartbeat().IsZero(); time.Sleep(5 * time.Millisecond) {
+			if time.Since(start) > 2*time.Second {
+				t.Fatal("LastHeartbeat() not updated after the ACK")
+			}
+		}
+	})
+
+	t.Run("no ACK", func(t *testing.T) {
+		// The receiver reads but never answers; the client gives up on the
+		// link and connects again.
+		connected := make(chan struct{}, 10)
+		host, port := receiver(t, "127.0.0.1:0", func(conn net.Conn) {
+			connected <- struct{}{}
+			bufio.NewReader(conn).WriteTo(io.Discard)
+		})
+		cfg := &config.ClientConfig{
+			Host:             host,
+			Port:             port,
+			ReconnectInitial: 10 * time.Millisecond,
+			ReconnectMax:     20 * time.Millisecond,
+			Heartbeat:        config.HeartbeatConfig{Interval: 20 * time.Millisecond, Timeout: 50 * time.Millisecond, Frame: frame},
+		}
+		c := startClient(t, cfg, queue.New(10))
+
+		for i := 0; i < 2; i++ {
+			select {
+			case <-connected:
+			case <-time.After(2 * time.Second):
+				t.Fatalf("%d connections, want a reconnect after the missing ACK", i)
+			}
+		}
+		if !c.LastHeartbeat().IsZero() {
+			t.Errorf("LastHeartbeat() = %v without an ACK", c.LastHeartbeat())
+		}
+	})
+}
@@ -0,0 +1,206 @@
+package client
+
+import (
+	"bufio"
+	"cid_retranslator/cidParser"
+	"cid_retranslator/config"
+	"cid_retranslator/dc09"
+	"cid_retranslator/queue"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// noTag marks a reply that does not say which message it answers; it is
+// matched with the oldest message in flight.
+const noTag = -1
+
+// codec frames outgoing Contact ID messages and interprets the receiver's
+// replies for one output protocol. A codec is created per connection.
+type codec interface {
+	// encode converts the Contact ID payload into the wire format. tag
+	// identifies the message in the receiver's reply.
+	encode(payload []byte) (frame []byte, tag int, err error)
+	// heartbeat returns a link-test frame and its tag.
+	heartbeat() (frame []byte, tag int, err error)
+	// readReply reads one complete reply, however the bytes are split or
+	// coalesced on the wire. It runs on the connection's reader goroutine
+	// and must not touch the codec state.
+	readReply(r *bufio.Reader) ([]byte, error)
+	// decode interprets a reply read by readReply and returns the tag of the
+	// message it answers, or noTag. ok is false for replies to ignore.
+	decode(raw []byte) (reply queue.DeliveryData, tag int, ok bool)
+	// done drops the state kept for an answered message.
+	done(tag int)
+}
+
+func newCodec(cfg *config.ClientConfig, seq *sequence, keys dc09.Keyring) codec {
+	if cfg.Protocol == config.ProtocolDC09 {
+		return &dc09Codec{receiver: cfg.DC09.Receiver, line: cfg.DC09.Line, seq: seq, keys: keys, sent: make(map[int]sentFrame)}
+	}
+	return &surgardCodec{heartbeatFrame: cfg.Heartbeat.Frame}
+}
+
+// surgardCodec sends raw 0x14-terminated frames. The receiver answers every
+// frame in order with a single ACK or NAK byte.
+type surgardCodec struct {
+	heartbeatFrame string
+}
+
+func (surgardCodec) encode(payload []byte) ([]byte, int, error) {
+	return payload, noTag, nil
+}
+
+func (c surgardCodec) heartbeat() ([]byte, int, error) {
+	return append([]byte(c.heartbeatFrame), 0x14), noTag, nil
+}
+
+// readReply skips line endings and padding some receivers send around the
+// reply byte.
+func (surgardCodec) readReply(r *bufio.Reader) ([]byte, error) {
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		switch b {
+		case '\r', '\n', 0x00:
+			continue
+		}
+		return []byte{b}, nil
+	}
+}
+
+func (surgardCodec) decode(raw []byte) (queue.DeliveryData, int, bool) {
+	slog.Debug("Reply from server", "reply", fmt.Sprintf("0x%02x", raw[0]))
+	if raw[0] == 0x06 {
+		return queue.DeliveryData{Status: true, Response: "ACK"}, noTag, true
+	}
+	return queue.DeliveryData{Status: false, Response: "NACK"}, noTag, true
+}
+
+func (surgardCodec) done(int) {}
+
+// sequence hands out DC-09 sequence numbers 0001-9999 and survives reconnects.
+type sequence struct {
+	mu   sync.Mutex
+	last int
+}
+
+func (s *sequence) next() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.last = s.last%9999 + 1
+	return s.last
+}
+
+// dc09Codec wraps messages into ADM-CID frames. Replies carry the sequence
+// number of the message they answer.
+type dc09Codec struct {
+	receiver string
+	line     string
+	seq      *sequence
+	keys     dc09.Keyring
+	sent     map[int]sentFrame // by sequence, until answered
+}
+
+// sentFrame is what decoding a reply needs to know about the message.
+type sentFrame struct {
+	account string
+	key     []byte // nil when unencrypted
+}
+
+// heartbeat returns a NULL message, encrypted when a "*" key is configured.
+func (c *dc09Codec) heartbeat() ([]byte, int, error) {
+	frame := &dc09.Frame{
+		ID:       dc09.IDNull,
+		Sequence: c.seq.next(),
+		Receiver: c.receiver,
+		Line:     c.line,
+		Account:  "0",
+	}
+	sent := sentFrame{account: frame.Account}
+	if key, ok := c.keys.Lookup(frame.Account); ok {
+		if err := frame.Encrypt(key); err != nil {
+			return nil, 0, fmt.Errorf("cannot encrypt DC-09 heartbeat: %w", err)
+		}
+		sent.key = key
+	}
+	c.sent[frame.Sequence] = sent
+	return frame.Encode(), frame.Sequence, nil
+}
+
+func (c *dc09Codec) encode(payload []byte) ([]byte, int, error) {
+	msg, err := cidparser.Parse(payload)
+	if err != nil {
+		return nil, 0, fmt.Errorf("cannot encode DC-09 frame: %w", err)
+	}
+	data, err := dc09.ADMData(msg)
+	if err != nil {
+		return nil, 0, fmt.Errorf("cannot encode DC-09 frame: %w", err)
+	}
+
+	frame := &dc09.Frame{
+		ID:        dc09.IDCID,
+		Sequence:  c.seq.next(),
+		Receiver:  c.receiver,
+		Line:      c.line,
+		Account:   fmt.Sprintf("%04d", msg.Account),
+		Data:      data,
+		Timestamp: time.Now(),
+	}
+	sent := sentFrame{account: frame.Account}
+	if key, ok := c.keys.Lookup(frame.Account); ok {
+		if err := frame.Encrypt(key); err != nil {
+			return nil, 0, fmt.Errorf("cannot encrypt DC-09 frame for account %s: %w", frame.Account, err)
+		}
+		sent.key = key
+	}
+	c.sent[frame.Sequence] = sent
+	return frame.Encode(), frame.Sequence, nil
+}
+
+func (*dc09Codec) readReply(r *bufio.Reader) ([]byte, error) {
+	return dc09.ReadFrame(r)
+}
+
+func (c *dc09Codec) decode(raw []byte) (queue.DeliveryData, int, bool) {
+	frame, err := dc09.Decode(raw)
+	if err != nil {
+		slog.Warn("Ignoring malformed DC-09 reply", "error", err, "reply", string(raw))
+		return queue.DeliveryData{}, 0, false
+	}
+
+	// NAK carries no sequence number; it refers to the oldest message.
+	tag := noTag
+	if frame.ID != dc09.IDNAK {
+		tag = frame.Sequence
+	}
+	if frame.Encrypted {
+		sent, ok := c.sent[frame.Sequence]
+		if !ok || sent.key == nil {
+			slog.Warn("Ignoring encrypted DC-09 reply to an unencrypted message", "id", frame.ID, "seq", frame.Sequence)
+			return queue.DeliveryData{}, 0, false
+		}
+		if err := frame.Decrypt(sent.key); err != nil {
+			slog.Warn("Cannot decrypt DC-09 reply, check the key for this account", "account", sent.account, "error", err)
+			return queue.DeliveryData{Status: false, Response: dc09.IDNAK}, tag, true
+		}
+	}
+	slog.Debug("Reply from server", "id", frame.ID, "seq", frame.Sequence)
+
+	switch frame.ID {
+	case dc09.IDNAK:
+		return queue.DeliveryData{Status: false, Response: dc09.IDNAK}, tag, true
+	case dc09.IDACK, dc09.IDDUH:
+		return queue.DeliveryData{Status: frame.ID == dc09.IDACK, Response: frame.ID}, tag, true
+	default:
+		slog.Warn("Ignoring unexpected DC-09 reply", "id", frame.ID)
+		return queue.DeliveryData{}, 0, false
+	}
+}
+
+func (c *dc09Codec) done(tag int) {
+	delete(c.sent, tag)
+}
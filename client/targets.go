@@ -0,0 +1,198 @@
+package client
+
+import (
+	"cid_retranslator/config"
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	defaultFailoverAfter    = 3
+	defaultFailbackInterval = 30 * time.Second
+	dialTimeout             = 10 * time.Second
+)
+
+// target is one downstream receiver and its health.
+type target struct {
+	name      string
+	address   string
+	connected bool
+	failures  int // consecutive
+	lastError string
+	lastOK    time.Time
+	// certExpiry is when the receiver's TLS certificate expires.
+	certExpiry time.Time
+}
+
+// TargetStatus reports the health of a downstream receiver.
+type TargetStatus struct {
+	Name      string `json:"name"`
+	Address   string `json:"address"`
+	Active    bool   `json:"active"`
+	Connected bool   `json:"connected"`
+	Failures  int    `json:"failures"`
+	LastError string `json:"lastError"`
+	LastOK    string `json:"lastOK"`
+	// CertExpiry is when the receiver's TLS certificate expires, empty
+	// without TLS.
+	CertExpiry string `json:"certExpiry"`
+}
+
+// targets is the ordered receiver list with the index of the one in use.
+type targets struct {
+	mu               sync.Mutex
+	list             []*target
+	active           int
+	failoverAfter    int
+	failbackInterval time.Duration
+}
+
+func newTargets(cfg *config.ClientConfig) *targets {
+	t := &targets{
+		failoverAfter:    cfg.FailoverAfter,
+		failbackInterval: cfg.FailbackInterval,
+	}
+	if t.failoverAfter <= 0 {
+		t.failoverAfter = defaultFailoverAfter
+	}
+	if t.failbackInterval <= 0 {
+		t.failbackInterval = defaultFailbackInterval
+	}
+	for i, tc := range cfg.EffectiveTargets() {
+		name := tc.Name
+		if name == "" {
+			name = fmt.Sprintf("target%d", i+1)
+		}
+		t.list = append(t.list, &target{name: name, address: net.JoinHostPort(tc.Host, tc.Port)})
+	}
+	return t
+}
+
+// current returns the target to connect to.
+func (t *targets) current() *target {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.list[t.active]
+}
+
+// failed records a dial error or lost connection and reports whether the
+// client switched to the next target.
+func (t *targets) failed(tg *target, err error) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	tg.failures++
+	tg.lastError = err.Error()
+	if len(t.list) == 1 || tg.failures < t.failoverAfter || t.list[t.active] != tg {
+		return false
+	}
+
+	t.active = (t.active + 1) % len(t.list)
+	next := t.list[t.active]
+	next.failures = 0
+	slog.Warn("Failing over to next target", "from", tg.address, "to", next.address, "failures", tg.failures)
+	return true
+}
+
+// ok records a reply from the target.
+func (t *targets) ok(tg *target) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	tg.failures = 0
+	tg.lastOK = time.Now()
+}
+
+func (t *targets) setConnected(tg *target, connected bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	tg.connected = connected
+}
+
+func (t *targets) setCertExpiry(tg *target, expiry time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	tg.certExpiry = expiry
+}
+
+// failBack makes the primary active again.
+func (t *targets) failBack() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.active = 0
+	t.list[0].failures = 0
+}
+
+// watchPrimary probes the primary while tg, a backup, is in use and calls
+// stop once the primary accepts connections again.
+func (t *targets) watchPrimary(ctx context.Context, tg *target, stop context.CancelFunc) {
+	primary := t.list[0]
+	if tg == primary {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(t.failbackInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			dialer := net.Dialer{Timeout: dialTimeout}
+			probe, err := dialer.DialContext(ctx, "tcp", primary.address)
+			if err != nil {
+				slog.Debug("Primary target still unavailable", "target", primary.address, "error", err)
+				continue
+			}
+			probe.Close()
+
+			slog.Info("Primary target recovered, failing back", "from", tg.address, "to", primary.address)
+			t.failBack()
+			stop()
+			return
+		}
+	}()
+}
+
+func (t *targets) status() []TargetStatus {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	status := make([]TargetStatus, len(t.list))
+	for i, tg := range t.list {
+		status[i] = TargetStatus{
+			Name:       tg.name,
+			Address:    tg.address,
+			Active:     i == t.active,
+			Connected:  tg.connected,
+			Failures:   tg.failures,
+			LastError:  tg.lastError,
+			LastOK:     formatTime(tg.lastOK),
+			CertExpiry: formatTime(tg.certExpiry),
+		}
+	}
+	return status
+}
+
+// Targets returns the health of every configured receiver, primary first.
+func (client *Client) Targets() []TargetStatus {
+	return client.targets.status()
+}
+
+// ActiveTarget returns the address of the receiver currently in use.
+func (client *Client) ActiveTarget() string {
+	return client.targets.current().address
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format("2006-01-02 15:04:05")
+}
@@ -0,0 +1,111 @@
+package client
+
+import (
+	"cid_retranslator/config"
+	"cid_retranslator/queue"
+	"net"
+	"testing"
+	"time"
+)
+
+// awaitFrame waits for a frame received by an acker.
+func awaitFrame(t *testing.T, got <-chan string, within time.Duration) string {
+	t.Helper()
+	select {
+	case frame := <-got:
+		return frame
+	case <-time.After(within):
+		t.Fatalf("nothing received within %s", within)
+		return ""
+	}
+}
+
+func TestClient_Failover(t *testing.T) {
+	primaryHost, primaryPort := closedPort(t)
+	got := make(chan string, 10)
+	backupHost, backupPort := receiver(t, "127.0.0.1:0", acker(got))
+
+	cfg := &config.ClientConfig{
+		Targets: []config.TargetConfig{
+			{Name: "primary", Host: primaryHost, Port: primaryPort},
+			{Name: "backup", Host: backupHost, Port: backupPort},
+		},
+		FailoverAfter:    2,
+		FailbackInterval: time.Hour,
+		ReconnectInitial: 10 * time.Millisecond,
+		ReconnectMax:     20 * time.Millisecond,
+		// Every failed dial counts as an attempt for a waiting sender.
+		Retry: config.RetryConfig{Attempts: 5},
+	}
+	q := queue.New(10)
+	c := startClient(t, cfg, q)
+
+	replyCh, err := q.Dispatch([]byte(testMessage), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reply := awaitReply(t, replyCh, 2*time.Second); !reply.Status {
+		t.Fatalf("reply = %+v, want ACK from the backup", reply)
+	}
+	if frame := awaitFrame(t, got, time.Second); frame != testMessage {
+		t.Errorf("backup received %q", frame)
+	}
+
+	backup := net.JoinHostPort(backupHost, backupPort)
+	if active := c.ActiveTarget(); active != backup {
+		t.Errorf("ActiveTarget() = %s, want %s", active, backup)
+	}
+	status := c.Targets()
+	if status[0].Active || status[0].Failures < 2 || status[0].LastError == "" {
+		t.Errorf("primary status = %+v, want inactive after 2 failures", status[0])
+	}
+	if !status[1].Active || status[1].LastOK == "" {
+		t.Errorf("backup status = %+v, want active with a reply", status[1])
+	}
+}
+
+func TestClient_Failback(t *testing.T) {
+	primaryHost, primaryPort := closedPort(t)
+	fromBackup := make(chan string, 10)
+	backupHost, backupPort := receiver(t, "127.0.0.1:0", acker(fromBackup))
+
+	cfg := &config.ClientConfig{
+		Targets: []config.TargetConfig{
+			{Name: "primary", Host: primaryHost, Port: primaryPort},
+			{Name: "backup", Host: backupHost, Port: backupPort},
+		},
+		FailoverAfter:    1,
+		FailbackInterval: 50 * time.Millisecond,
+		ReconnectInitial: 10 * time.Millisecond,
+		ReconnectMax:     20 * time.Millisecond,
+	}
+	q := queue.New(10)
+	c := startClient(t, cfg, q)
+
+	if _, err := q.Dispatch([]byte(testMessage), false); err != nil {
+		t.Fatal(err)
+	}
+	awaitFrame(t, fromBackup, 2*time.Second)
+
+	// The primary comes back on its old address.
+	fromPrimary := make(chan string, 10)
+	receiver(t, net.JoinHostPort(primaryHost, primaryPort), acker(fromPrimary))
+	primary := net.JoinHostPort(primaryHost, primaryPort)
+	for start := time.Now(); c.ActiveTarget() != primary; time.Sleep(10 * time.Millisecond) {
+		if time.Since(start) > 2*time.Second {
+			t.Fatal("client did not fail back to the primary")
+		}
+	}
+
+	if _, err := q.Dispatch([]byte(testMessage), false); err != nil {
+		t.Fatal(err)
+	}
+	if frame := awaitFrame(t, fromPrimary, 2*time.Second); frame != testMessage {
+		t.Errorf("primary received %q", frame)
+	}
+	select {
+	case frame := <-fromBackup:
+		t.Errorf("backup received %q after fail-back", frame)
+	default:
+	}
+}
@@ -0,0 +1,45 @@
+package client
+
+import (
+	"cid_retranslator/config"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"time"
+)
+
+// dial connects to the target, with a TLS handshake when configured. It
+// refuses to connect when the TLS or DC-09 key settings are broken.
+func (client *Client) dial(ctx context.Context, tg *target) (net.Conn, error) {
+	if client.setupErr != nil {
+		return nil, client.setupErr
+	}
+	dialer := net.Dialer{Timeout: dialTimeout}
+	conn, err := dialer.DialContext(ctx, "tcp", tg.address)
+	if err != nil || client.tlsConfig == nil {
+		return conn, err
+	}
+
+	cfg := client.tlsConfig.Clone()
+	if cfg.ServerName == "" {
+		cfg.ServerName, _, _ = net.SplitHostPort(tg.address)
+	}
+	tlsConn := tls.Client(conn, cfg)
+	handshakeCtx, cancel := context.WithTimeout(ctx, dialTimeout)
+	defer cancel()
+	if err := tlsConn.HandshakeContext(handshakeCtx); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("TLS handshake failed: %w", err)
+	}
+	if peer := tlsConn.ConnectionState().PeerCertificates; len(peer) > 0 {
+		client.targets.setCertExpiry(tg, peer[0].NotAfter)
+	}
+	return tlsConn, nil
+}
+
+// CertExpiry returns when the client certificate expires, zero if none is
+// configured.
+func (client *Client) CertExpiry() time.Time {
+	return config.CertExpiry(client.tlsConfig)
+}
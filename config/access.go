@@ -0,0 +1,61 @@
+package config
+
+import (
+	"fmt"
+	"net/netip"
+	"strings"
+)
+
+// AccessConfig restricts who may send to the listener.
+type AccessConfig struct {
+	// Allow lists the addresses or CIDR ranges that may connect. Empty
+	// allows every source that is not denied.
+	Allow []string `yaml:"allow" json:"allow"`
+	// Deny lists sources that are rejected even when allowed.
+	Deny []string `yaml:"deny" json:"deny"`
+	// MaxConnections limits concurrent inbound connections. Zero means no
+	// limit.
+	MaxConnections int `yaml:"maxconnections" json:"maxConnections"`
+	// RateLimit is the number of messages per second accepted from one
+	// source address, with bursts of up to RateBurst (default: one second's
+	// worth). Messages above the limit get a NACK. Zero disables the limit.
+	RateLimit float64 `yaml:"ratelimit" json:"rateLimit"`
+	RateBurst int     `yaml:"rateburst" json:"rateBurst"`
+}
+
+// Validate checks the address lists and limits.
+func (a AccessConfig) Validate() error {
+	if _, err := ParsePrefixes(a.Allow); err != nil {
+		return fmt.Errorf("allow: %w", err)
+	}
+	if _, err := ParsePrefixes(a.Deny); err != nil {
+		return fmt.Errorf("deny: %w", err)
+	}
+	if a.MaxConnections < 0 || a.RateLimit < 0 || a.RateBurst < 0 {
+		return fmt.Errorf("limits must not be negative")
+	}
+	return nil
+}
+
+// ParsePrefixes parses CIDR ranges such as "10.0.0.0/8". A plain address
+// stands for itself.
+func ParsePrefixes(list []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(list))
+	for _, s := range list {
+		s = strings.TrimSpace(s)
+		if !strings.Contains(s, "/") {
+			addr, err := netip.ParseAddr(s)
+			if err != nil {
+				return nil, fmt.Errorf("invalid address '%s'", s)
+			}
+			prefixes = append(prefixes, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR range '%s'", s)
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
@@ -1,8 +1,11 @@
 package config
 
 import (
+	"encoding/hex"
+	"fmt"
 	"log/slog"
 	"os"
+	"regexp"
 	"time"
 
 	"gopkg.in/yaml.v3"
@@ -15,25 +18,372 @@ type Config struct {
 	Queue    QueueConfig  `yaml:"queue"`
 	Logging  LoggingConfig `yaml:"logging"`
 	CIDRules CIDRules     `yaml:"cidrules"`
+	// Clients lists several central stations. When set, Client is ignored
+	// and every message is delivered to all of them.
+	Clients []ClientConfig `yaml:"clients"`
+	Fanout  FanoutConfig   `yaml:"fanout"`
+	Routes  []RouteConfig  `yaml:"routes"`
+	// Listeners lists several inbound ports. When set, Server and CIDRules
+	// are ignored.
+	Listeners []ListenerConfig `yaml:"listeners"`
 }
 
+// EffectiveClients returns Clients, or Client named "default" when no list
+// is configured.
+func (c *Config) EffectiveClients() []ClientConfig {
+	if len(c.Clients) > 0 {
+		return c.Clients
+	}
+	client := c.Client
+	if client.Name == "" {
+		client.Name = "default"
+	}
+	return []ClientConfig{client}
+}
+
+// Fan-out ACK policies.
+const (
+	FanoutAny     = "any"
+	FanoutAll     = "all"
+	FanoutPrimary = "primary"
+)
+
+// FanoutConfig decides when a message delivered to several clients is
+// acknowledged to the sender.
+type FanoutConfig struct {
+	// Ack is "all" (default), "any" or "primary".
+	Ack string `yaml:"ack"`
+	// Primary names the client whose result counts for the "primary" policy.
+	Primary string `yaml:"primary"`
+}
+
+// Validate checks the policy against the configured client names.
+func (f FanoutConfig) Validate(clients []ClientConfig) error {
+	switch f.Ack {
+	case "", FanoutAll, FanoutAny:
+		return nil
+	case FanoutPrimary:
+		for _, c := range clients {
+			if c.Name == f.Primary {
+				return nil
+			}
+		}
+		return fmt.Errorf("fanout.primary: no client named '%s'", f.Primary)
+	default:
+		return fmt.Errorf("fanout: unknown ack policy '%s'", f.Ack)
+	}
+}
+
+// Listener protocols.
+const (
+	ProtocolSurgard = "surgard"
+	ProtocolDC09    = "dc09"
+)
+
+// Listener acknowledgement modes.
+const (
+	// AckDelivery answers the sender only after the downstream receiver replied.
+	AckDelivery = "delivery"
+	// AckStore answers as soon as the message is in the queue journal; the
+	// client delivers it later.
+	AckStore = "store"
+)
+
 // ServerConfig holds server-specific configuration.
 type ServerConfig struct {
+	// Name identifies the listener in stats and logs.
+	Name string `yaml:"name"`
 	Host string `yaml:"host"`
 	Port string `yaml:"port"`
+	// Protocol is "surgard" (0x14-terminated Contact ID, default) or "dc09".
+	Protocol string `yaml:"protocol"`
+	// UDP additionally listens for DC-09 datagrams on the same port.
+	UDP bool `yaml:"udp"`
+	// DC09Keys decrypt '*'-prefixed DC-09 messages.
+	DC09Keys []DC09Key `yaml:"dc09keys"`
+	// Heartbeats are regular expressions matched against incoming Surgard
+	// frames without the 0x14 terminator. Matching frames are ACKed locally.
+	Heartbeats []string `yaml:"heartbeats"`
+	// AckMode is "delivery" (default) or "store".
+	AckMode string `yaml:"ackmode"`
+	// ReplyTimeout is how long a sender is held waiting for the downstream
+	// result in "delivery" ack mode before it gets a NACK. Zero means 10s.
+	// It should cover all client retries.
+	ReplyTimeout time.Duration `yaml:"replytimeout"`
+	// DedupWindow ACKs repeated copies of an already forwarded event
+	// (same account, qualifier, code, partition and zone) without forwarding
+	// them again. Zero disables suppression.
+	DedupWindow time.Duration `yaml:"dedupwindow"`
+	// TLS encrypts inbound connections. DC-09 over UDP stays unencrypted.
+	TLS TLSConfig `yaml:"tls"`
+	// Access restricts the sources that may connect and send.
+	Access AccessConfig `yaml:"access"`
+	// IdleTimeout closes inbound connections that send nothing, not even a
+	// heartbeat, for this long. Zero keeps them open indefinitely.
+	IdleTimeout time.Duration `yaml:"idletimeout"`
+	// ReadTimeout bounds the time to receive the rest of a frame once its
+	// first byte arrived. Zero means no limit.
+	ReadTimeout time.Duration `yaml:"readtimeout"`
+	// KeepAlive is the TCP keepalive idle time and probe interval that
+	// detects dead peers. Zero means 15s, negative disables keepalive.
+	KeepAlive time.Duration `yaml:"keepalive"`
+	// KeepAliveCount is the number of unanswered probes before the
+	// connection is dropped. Zero uses the system default.
+	KeepAliveCount int `yaml:"keepalivecount"`
+}
+
+// Validate checks the listener settings.
+func (s ServerConfig) Validate() error {
+	switch s.Protocol {
+	case "", ProtocolSurgard:
+		if s.UDP {
+			return fmt.Errorf("server: udp is only supported for protocol '%s'", ProtocolDC09)
+		}
+	case ProtocolDC09:
+	default:
+		return fmt.Errorf("server: unknown protocol '%s'", s.Protocol)
+	}
+	switch s.AckMode {
+	case "", AckDelivery, AckStore:
+	default:
+		return fmt.Errorf("server: unknown ackmode '%s'", s.AckMode)
+	}
+	if s.DedupWindow < 0 {
+		return fmt.Errorf("server: dedupwindow must not be negative")
+	}
+	if s.ReplyTimeout < 0 {
+		return fmt.Errorf("server: replytimeout must not be negative")
+	}
+	if err := s.TLS.Validate(true); err != nil {
+		return fmt.Errorf("server.tls: %w", err)
+	}
+	if err := s.Access.Validate(); err != nil {
+		return fmt.Errorf("server.access: %w", err)
+	}
+	if s.IdleTimeout < 0 || s.ReadTimeout < 0 || s.KeepAliveCount < 0 {
+		return fmt.Errorf("server: idletimeout, readtimeout and keepalivecount must not be negative")
+	}
+	for i, k := range s.DC09Keys {
+		if err := k.Validate(); err != nil {
+			return fmt.Errorf("server.dc09keys[%d]: %w", i, err)
+		}
+	}
+	for i, pattern := range s.Heartbeats {
+		if _, err := regexp.Compile(pattern); err != nil {
+			return fmt.Errorf("server.heartbeats[%d]: %w", i, err)
+		}
+	}
+	return nil
 }
 
 // ClientConfig holds client-specific configuration.
 type ClientConfig struct {
+	// Name identifies the client in stats and fan-out settings.
+	Name             string        `yaml:"name"`
 	Host             string        `yaml:"host"`
 	Port             string        `yaml:"port"`
 	ReconnectInitial time.Duration `yaml:"reconnectinitial"`
 	ReconnectMax     time.Duration `yaml:"reconnectmax"`
+	// Protocol is "surgard" (raw Contact ID, default) or "dc09".
+	Protocol string `yaml:"protocol"`
+	// DC09 is used when Protocol is "dc09".
+	DC09 DC09ClientConfig `yaml:"dc09"`
+	// Heartbeat sends a link test when the connection is idle.
+	Heartbeat HeartbeatConfig `yaml:"heartbeat"`
+	// ReplyTimeout bounds the wait for the receiver's answer to a message.
+	// Zero waits indefinitely for Surgard and 10s for DC-09.
+	ReplyTimeout time.Duration `yaml:"replytimeout"`
+	// Retry controls how often a message is re-sent after a NACK or a lost
+	// connection before it is reported as failed.
+	Retry RetryConfig `yaml:"retry"`
+	// Window is the number of messages sent before waiting for a reply. Zero
+	// means 1. Only raise it for receivers that answer every message in order
+	// (Surgard) or by sequence number (DC-09).
+	Window int `yaml:"window"`
+	// Targets lists receivers in order of preference; the first one is the
+	// primary. When empty, Host and Port are the only target.
+	Targets []TargetConfig `yaml:"targets"`
+	// FailoverAfter is the number of consecutive failures (dial errors or
+	// lost connections) before the next target is tried. Zero means 3.
+	FailoverAfter int `yaml:"failoverafter"`
+	// FailbackInterval is how often the primary is probed while a backup is
+	// active. Zero means 30s.
+	FailbackInterval time.Duration `yaml:"failbackinterval"`
+	// TLS encrypts the connections to all targets.
+	TLS TLSConfig `yaml:"tls"`
+}
+
+// TargetConfig is one downstream receiver.
+type TargetConfig struct {
+	Name string `yaml:"name"`
+	Host string `yaml:"host"`
+	Port string `yaml:"port"`
+}
+
+// EffectiveTargets returns Targets, or Host and Port as a single target.
+func (c *ClientConfig) EffectiveTargets() []TargetConfig {
+	if len(c.Targets) > 0 {
+		return c.Targets
+	}
+	return []TargetConfig{{Name: "primary", Host: c.Host, Port: c.Port}}
+}
+
+// RetryConfig holds the per-message retry policy. The delay starts at
+// Backoff and doubles up to BackoffMax.
+type RetryConfig struct {
+	Attempts   int           `yaml:"attempts"` // total sends, 0 means one
+	Backoff    time.Duration `yaml:"backoff"`
+	BackoffMax time.Duration `yaml:"backoffmax"`
+}
+
+// HeartbeatConfig controls the outbound link test.
+type HeartbeatConfig struct {
+	Interval time.Duration `yaml:"interval"` // idle time before a heartbeat, 0 disables
+	Timeout  time.Duration `yaml:"timeout"`  // time to wait for the ACK
+	Frame    string        `yaml:"frame"`    // Surgard frame without the 0x14 terminator
+}
+
+// DC09ClientConfig holds the routing fields of outgoing DC-09 frames.
+type DC09ClientConfig struct {
+	Receiver string `yaml:"receiver"` // hex receiver number, optional
+	Line     string `yaml:"line"`     // hex line prefix
+	// Keys encrypt outgoing messages of the listed accounts.
+	Keys []DC09Key `yaml:"keys"`
+}
+
+// Secret holds key material. It formats as "[redacted]" so it never ends up
+// in logs; use string(s) to read the value.
+type Secret string
+
+func (s Secret) String() string { return "[redacted]" }
+
+// LogValue implements slog.LogValuer.
+func (s Secret) LogValue() slog.Value { return slog.StringValue("[redacted]") }
+
+// DC09Key assigns a hex AES-128/192/256 key to a DC-09 account. Account "*"
+// applies to every account without a key of its own.
+type DC09Key struct {
+	Account string `yaml:"account"`
+	Key     Secret `yaml:"key"`
+}
+
+// Validate checks the key length without echoing the key.
+func (k DC09Key) Validate() error {
+	if k.Account == "" {
+		return fmt.Errorf("missing account")
+	}
+	key, err := hex.DecodeString(string(k.Key))
+	if err != nil {
+		return fmt.Errorf("key for account %s is not valid hex", k.Account)
+	}
+	switch len(key) {
+	case 16, 24, 32:
+		return nil
+	}
+	return fmt.Errorf("key for account %s must be 16, 24 or 32 bytes, got %d", k.Account, len(key))
 }
 
+// Validate checks the client settings.
+func (c ClientConfig) Validate() error {
+	switch c.Protocol {
+	case "", ProtocolSurgard, ProtocolDC09:
+	default:
+		return fmt.Errorf("client: unknown protocol '%s'", c.Protocol)
+	}
+	for i, k := range c.DC09.Keys {
+		if err := k.Validate(); err != nil {
+			return fmt.Errorf("client.dc09.keys[%d]: %w", i, err)
+		}
+	}
+	if c.Heartbeat.Interval > 0 && c.Heartbeat.Timeout <= 0 {
+		return fmt.Errorf("client.heartbeat: timeout must be set when interval is")
+	}
+	if c.ReplyTimeout < 0 {
+		return fmt.Errorf("client: replytimeout must not be negative")
+	}
+	if c.Retry.Attempts < 0 || c.Retry.Backoff < 0 || c.Retry.BackoffMax < 0 {
+		return fmt.Errorf("client.retry: values must not be negative")
+	}
+	for i, t := range c.Targets {
+		if t.Host == "" || t.Port == "" {
+			return fmt.Errorf("client.targets[%d]: host and port are required", i)
+		}
+	}
+	if c.FailoverAfter < 0 || c.FailbackInterval < 0 {
+		return fmt.Errorf("client: failoverafter and failbackinterval must not be negative")
+	}
+	if c.Window < 0 {
+		return fmt.Errorf("client: window must not be negative")
+	}
+	if err := c.TLS.Validate(false); err != nil {
+		return fmt.Errorf("client.tls: %w", err)
+	}
+	return nil
+}
+
+// Queue journal fsync policies.
+const (
+	FsyncAlways   = "always"
+	FsyncInterval = "interval"
+	FsyncNever    = "never"
+)
+
 // QueueConfig holds queue-specific configuration.
 type QueueConfig struct {
 	BufferSize int `yaml:"buffersize"`
+	// Dir holds the queue journal. When empty the queue is kept in memory only
+	// and buffered messages are lost on exit.
+	Dir string `yaml:"dir"`
+	// Fsync is "always" (default), "interval" or "never".
+	Fsync         string        `yaml:"fsync"`
+	FsyncInterval time.Duration `yaml:"fsyncinterval"`
+	// CompactAfter rewrites the journal after this many acknowledged messages.
+	CompactAfter int `yaml:"compactafter"`
+	// Priorities lists the priority lanes, highest first. Messages whose
+	// event code matches no lane go to the "default" lane, which is served last.
+	Priorities []PriorityClass `yaml:"priorities"`
+	// MaxDeadLetters caps the dead-letter store; the oldest entries are
+	// dropped first. Zero keeps every entry.
+	MaxDeadLetters int `yaml:"maxdeadletters"`
+}
+
+// DefaultLane names the lane for messages matching no priority class.
+const DefaultLane = "default"
+
+// PriorityClass assigns Contact ID event codes to a queue lane.
+type PriorityClass struct {
+	Name  string  `yaml:"name"`
+	Codes []Range `yaml:"codes"`
+}
+
+// Validate checks the journal settings.
+func (c *QueueConfig) Validate() error {
+	switch c.Fsync {
+	case "", FsyncAlways, FsyncNever:
+	case FsyncInterval:
+		if c.FsyncInterval <= 0 {
+			return fmt.Errorf("queue.fsyncinterval must be set for fsync %q", c.Fsync)
+		}
+	default:
+		return fmt.Errorf("queue.fsync: unknown policy %q", c.Fsync)
+	}
+	if c.CompactAfter < 0 {
+		return fmt.Errorf("queue.compactafter must not be negative")
+	}
+	if c.MaxDeadLetters < 0 {
+		return fmt.Errorf("queue.maxdeadletters must not be negative")
+	}
+	seen := map[string]bool{DefaultLane: true}
+	for i, p := range c.Priorities {
+		if p.Name == "" || seen[p.Name] {
+			return fmt.Errorf("queue.priorities[%d]: name '%s' is empty or already used", i, p.Name)
+		}
+		seen[p.Name] = true
+		if len(p.Codes) == 0 {
+			return fmt.Errorf("queue.priorities[%d]: codes must not be empty", i)
+		}
+	}
+	return nil
 }
 
 // LoggingConfig holds logging configuration.
@@ -50,25 +400,78 @@ type CIDRules struct {
 	RequiredPrefix string            `yaml:"requiredprefix"`
 	ValidLength    int               `yaml:"validlength"`
 	TestCodeMap    map[string]string `yaml:"testcodemap"`
-	AccNumOffset   int               `yaml:"accnumoffset"`
 	AccNumAdd      int               `yaml:"accnumadd"`
+	// AccountRules replaces the legacy AccNumAdd range when not empty.
+	AccountRules []AccountRule `yaml:"accountrules"`
+	// AccountMap is consulted before AccountRules.
+	AccountMap AccountMapConfig `yaml:"accountmap"`
+	// CodeRules are checked before TestCodeMap, first match wins.
+	CodeRules []CodeRule `yaml:"coderules"`
+	// Filters decide whether a message is forwarded at all, first match wins.
+	Filters []FilterRule `yaml:"filters"`
+}
+
+// AccountMapConfig describes an explicit old-account to new-account table.
+type AccountMapConfig struct {
+	File           string        `yaml:"file"`
+	Unknown        string        `yaml:"unknown"`
+	DefaultAccount int           `yaml:"defaultaccount"`
+	ReloadInterval time.Duration `yaml:"reloadinterval"`
 }
 
 // defaultConfig returns a new Config with default values.
 func defaultConfig() *Config {
 	return &Config{
 		Server: ServerConfig{
-			Host: "0.0.0.0",
-			Port: "20005",
+			Host:     "0.0.0.0",
+			Port:     "20005",
+			Protocol: ProtocolSurgard,
+			AckMode:  AckDelivery,
+			// Client retries: 3 x 5s reply timeout plus 1s and 2s backoff.
+			ReplyTimeout: 30 * time.Second,
+			// Panels resend within seconds when our ACK is lost.
+			DedupWindow: 10 * time.Second,
+			// Surgard link test, e.g. "1011           @    "
+			Heartbeats: []string{`^1\d{3}\s+@\s*$`},
+			// Senders heartbeat well within this, see Heartbeats.
+			IdleTimeout: 5 * time.Minute,
+			ReadTimeout: 10 * time.Second,
+			KeepAlive:   30 * time.Second,
 		},
 		Client: ClientConfig{
 			Host:             "10.32.1.49",
 			Port:             "20004",
 			ReconnectInitial: 1 * time.Second,
 			ReconnectMax:     60 * time.Second,
+			Protocol:         ProtocolSurgard,
+			DC09:             DC09ClientConfig{Line: "0"},
+			Heartbeat: HeartbeatConfig{
+				Interval: 30 * time.Second,
+				Timeout:  10 * time.Second,
+				Frame:    "1011           @    ",
+			},
+			ReplyTimeout:     5 * time.Second,
+			Window:           1,
+			FailoverAfter:    3,
+			FailbackInterval: 30 * time.Second,
+			Retry: RetryConfig{
+				Attempts:   3,
+				Backoff:    1 * time.Second,
+				BackoffMax: 4 * time.Second,
+			},
 		},
 		Queue: QueueConfig{
-			BufferSize: 100,
+			BufferSize:     100,
+			Dir:            "queue",
+			Fsync:          FsyncAlways,
+			CompactAfter:   1000,
+			MaxDeadLetters: 1000,
+			Priorities: []PriorityClass{
+				// Fire, panic, medical and burglary alarms
+				{Name: "alarm", Codes: []Range{{Min: 100, Max: 199, Set: true}}},
+				// Supervisory and trouble reports
+				{Name: "trouble", Codes: []Range{{Min: 200, Max: 399, Set: true}}},
+			},
 		},
 		Logging: LoggingConfig{
 			Filename:   "app.log",
@@ -81,8 +484,9 @@ func defaultConfig() *Config {
 			RequiredPrefix: "5",
 			ValidLength:    21,
 			TestCodeMap:    map[string]string{"E603": "E602"},
-			AccNumOffset:   2100,
-			AccNumAdd:      2100,
+			AccountRules: []AccountRule{
+				{Accounts: Range{Min: 2000, Max: 2200, Set: true}, Action: AccountActionAdd, Value: 2100},
+			},
 		},
 	}
 }
@@ -131,6 +535,41 @@ func load(path string) (*Config, error) {
 	if err := yaml.Unmarshal(data, &cfg); err != nil {
 		return nil, err
 	}
+	if err := cfg.Server.Validate(); err != nil {
+		return nil, err
+	}
+	if err := cfg.Client.Validate(); err != nil {
+		return nil, err
+	}
+	names := make(map[string]bool)
+	for i := range cfg.Clients {
+		c := &cfg.Clients[i]
+		if c.Name == "" || names[c.Name] {
+			return nil, fmt.Errorf("clients[%d]: name '%s' is empty or already used", i, c.Name)
+		}
+		names[c.Name] = true
+		if err := c.Validate(); err != nil {
+			return nil, fmt.Errorf("clients[%d]: %w", i, err)
+		}
+	}
+	if err := cfg.Fanout.Validate(cfg.EffectiveClients()); err != nil {
+		return nil, err
+	}
+	if err := validateRoutes(cfg.Routes, cfg.EffectiveClients(), cfg.Queue.Priorities); err != nil {
+		return nil, err
+	}
+	if err := cfg.Queue.Validate(); err != nil {
+		return nil, err
+	}
+	if cfg.Server.AckMode == AckStore && cfg.Queue.Dir == "" {
+		return nil, fmt.Errorf("server.ackmode '%s' requires queue.dir", AckStore)
+	}
+	if err := cfg.CIDRules.Validate(); err != nil {
+		return nil, err
+	}
+	if err := validateListeners(cfg.Listeners, cfg.Routes, cfg.Queue); err != nil {
+		return nil, err
+	}
 
 	return &cfg, nil
 }
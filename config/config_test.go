@@ -1,7 +1,11 @@
 package config
 
 import (
+	"bytes"
+	"fmt"
+	"log/slog"
 	"os"
+	"strings"
 	"testing"
 
 	"gopkg.in/yaml.v3"
@@ -53,3 +57,255 @@ func TestNew_LoadExistingConfig(t *testing.T) {
 	// Clean up the created file
 	os.Remove(configPath)
 }
+
+func TestParseRange(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    Range
+		wantErr bool
+	}{
+		{"", Range{}, false},
+		{"1234", Range{Min: 1234, Max: 1234, Set: true}, false},
+		{"2000-2200", Range{Min: 2000, Max: 2200, Set: true}, false},
+		{" 10 - 20 ", Range{Min: 10, Max: 20, Set: true}, false},
+		{"20-10", Range{}, true},
+		{"abc", Range{}, true},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseRange(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseRange(%q) error = %v, wantErr %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseRange(%q) = %+v, want %+v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestCIDRules_AccountRulesYAML(t *testing.T) {
+	data := []byte(`
+accountrules:
+  - accounts: 2000-2200
+    action: add
+    value: 2100
+  - accounts: 1234
+    action: replace
+    value: 4321
+`)
+	var rules CIDRules
+	if err := yaml.Unmarshal(data, &rules); err != nil {
+		t.Fatalf("yaml.Unmarshal() error: %v", err)
+	}
+	if err := rules.Validate(); err != nil {
+		t.Fatalf("Validate() error: %v", err)
+	}
+	if len(rules.AccountRules) != 2 || rules.AccountRules[1].Accounts != (Range{Min: 1234, Max: 1234, Set: true}) {
+		t.Errorf("unexpected account rules: %+v", rules.AccountRules)
+	}
+}
+
+func TestCIDRules_ValidateOverflow(t *testing.T) {
+	rules := CIDRules{AccountRules: []AccountRule{
+		{Accounts: Range{Min: 9000, Max: 9999, Set: true}, Action: AccountActionAdd, Value: 1},
+	}}
+	if err := rules.Validate(); err == nil {
+		t.Error("Validate() should reject rules producing 5-digit accounts")
+	}
+
+	rules.AccountRules[0] = AccountRule{Accounts: Range{Min: 0, Max: 10, Set: true}, Action: "multiply"}
+	if err := rules.Validate(); err == nil {
+		t.Error("Validate() should reject unknown actions")
+	}
+	legacy := CIDRules{AccNumAdd: 8000}
+	if err := legacy.Validate(); err == nil {
+		t.Error("Validate() should reject an accnumadd producing 5-digit accounts")
+	}
+	legacy.AccNumAdd = 2100
+	if err := legacy.Validate(); err != nil {
+		t.Errorf("Validate() unexpected error for accnumadd 2100: %v", err)
+	}
+}
+
+func TestSecret_Redacted(t *testing.T) {
+	key := DC09Key{Account: "1234", Key: "000102030405060708090A0B0C0D0E0F"}
+
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, nil))
+	logger.Info("key", "key", key.Key, "entry", key)
+	if strings.Contains(buf.String(), string(key.Key)) {
+		t.Errorf("log output leaks the key: %s", buf.String())
+	}
+	if s := fmt.Sprintf("%v %s", key.Key, key); strings.Contains(s, string(key.Key)) {
+		t.Errorf("fmt output leaks the key: %s", s)
+	}
+
+	// The configuration file still holds the real key.
+	data, err := yaml.Marshal(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), string(key.Key)) {
+		t.Errorf("yaml output lost the key: %s", data)
+	}
+}
+
+func TestDC09Key_Validate(t *testing.T) {
+	if err := (DC09Key{Account: "1234", Key: "00"}).Validate(); err == nil {
+		t.Errorf("Validate() should reject short keys, got %v", err)
+	}
+	if err := (DC09Key{Account: "*", Key: Secret(strings.Repeat("ab", 32))}).Validate(); err != nil {
+		t.Errorf("Validate() rejected an AES-256 key: %v", err)
+	}
+}
+
+func TestClientConfig_RejectsBadDC09Key(t *testing.T) {
+	cfg := ClientConfig{Protocol: ProtocolDC09, DC09: DC09ClientConfig{Keys: []DC09Key{{Account: "1234", Key: "not hex"}}}}
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "client.dc09.keys[0]") {
+		t.Errorf("Validate() error = %v, want key error", err)
+	}
+}
+
+func TestLoad_StoreAckModeRequiresJournal(t *testing.T) {
+	path := t.TempDir() + "/config.yaml"
+	cfg := &Config{Server: ServerConfig{AckMode: AckStore}}
+	data, _ := yaml.Marshal(cfg)
+	os.WriteFile(path, data, 0644)
+
+	if _, err := load(path); err == nil || !strings.Contains(err.Error(), "queue.dir") {
+		t.Errorf("load() error = %v, want queue.dir requirement", err)
+	}
+
+	cfg.Queue.Dir = t.TempDir()
+	data, _ = yaml.Marshal(cfg)
+	os.WriteFile(path, data, 0644)
+	if _, err := load(path); err != nil {
+		t.Errorf("load() unexpected error: %v", err)
+	}
+}
+
+func TestLoad_Routes(t *testing.T) {
+	tests := []struct {
+		name  string
+		route RouteConfig
+		want  string
+	}{
+		{"valid", RouteConfig{Name: "faults", Match: RouteMatch{Class: "trouble"}, Clients: []string{"default"}}, ""},
+		{"reserved name", RouteConfig{Name: "default", Clients: []string{"default"}}, "reserved"},
+		{"unknown client", RouteConfig{Name: "r", Clients: []string{"service"}}, "no client named"},
+		{"unknown class", RouteConfig{Name: "r", Match: RouteMatch{Class: "fire"}, Clients: []string{"default"}}, "unknown class"},
+		{"no clients", RouteConfig{Name: "r"}, "must not be empty"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := t.TempDir() + "/config.yaml"
+			cfg := &Config{
+				Queue:  QueueConfig{Priorities: []PriorityClass{{Name: "trouble", Codes: []Range{{Min: 300, Max: 399, Set: true}}}}},
+				Routes: []RouteConfig{tt.route},
+			}
+			data, _ := yaml.Marshal(cfg)
+			os.WriteFile(path, data, 0644)
+
+			_, err := load(path)
+			if tt.want == "" && err != nil {
+				t.Errorf("load() unexpected error: %v", err)
+			}
+			if tt.want != "" && (err == nil || !strings.Contains(err.Error(), tt.want)) {
+				t.Errorf("load() error = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestParsePrefixes(t *testing.T) {
+	prefixes, err := ParsePrefixes([]string{"10.1.2.3/8", " 192.168.1.5 ", "::ffff:172.16.0.1", "2001:db8::/32"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"10.0.0.0/8", "192.168.1.5/32", "172.16.0.1/32", "2001:db8::/32"}
+	for i, p := range prefixes {
+		if p.String() != want[i] {
+			t.Errorf("prefix %d = %s, want %s", i, p, want[i])
+		}
+	}
+
+	for _, bad := range []string{"10.0.0.0/33", "host.example", ""} {
+		if _, err := ParsePrefixes([]string{bad}); err == nil {
+			t.Errorf("ParsePrefixes(%q) expected error", bad)
+		}
+	}
+}
+
+func TestAccessConfig_Validate(t *testing.T) {
+	if err := (AccessConfig{Allow: []string{"10.0.0.0/8"}, MaxConnections: 5, RateLimit: 0.5}).Validate(); err != nil {
+		t.Errorf("Validate() unexpected error: %v", err)
+	}
+	if err := (AccessConfig{Deny: []string{"nope"}}).Validate(); err == nil || !strings.Contains(err.Error(), "deny") {
+		t.Errorf("Validate() error = %v, want deny error", err)
+	}
+	if err := (AccessConfig{RateLimit: -1}).Validate(); err == nil {
+		t.Error("Validate() expected error for negative rate limit")
+	}
+}
+
+func TestLoad_Listeners(t *testing.T) {
+	a := ListenerConfig{ServerConfig: ServerConfig{Name: "a", Port: "20005"}}
+	b := ListenerConfig{ServerConfig: ServerConfig{Name: "b", Port: "20006", Protocol: ProtocolDC09}, Route: "faults"}
+	named := func(l ListenerConfig, name string) ListenerConfig { l.Name = name; return l }
+	withRoute := func(l ListenerConfig, route string) ListenerConfig { l.Route = route; return l }
+	store := a
+	store.AckMode = AckStore
+	badRules := b
+	badRules.CIDRules.AccountRules = []AccountRule{{Action: "rename"}}
+
+	tests := []struct {
+		name      string
+		listeners []ListenerConfig
+		want      string
+	}{
+		{"valid", []ListenerConfig{a, b}, ""},
+		{"default route", []ListenerConfig{withRoute(a, "default")}, ""},
+		{"no name", []ListenerConfig{named(a, "")}, "empty or already used"},
+		{"duplicate name", []ListenerConfig{a, named(b, "a")}, "empty or already used"},
+		{"duplicate address", []ListenerConfig{a, named(a, "c")}, "already used"},
+		{"unknown route", []ListenerConfig{withRoute(a, "alarms")}, "unknown route"},
+		{"store without journal", []ListenerConfig{store}, "requires queue.dir"},
+		{"invalid rules", []ListenerConfig{a, badRules}, "listeners[1]: cidrules"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := t.TempDir() + "/config.yaml"
+			cfg := &Config{
+				Routes:    []RouteConfig{{Name: "faults", Clients: []string{"default"}}},
+				Listeners: tt.listeners,
+			}
+			data, _ := yaml.Marshal(cfg)
+			os.WriteFile(path, data, 0644)
+
+			loaded, err := load(path)
+			if tt.want == "" && err != nil {
+				t.Errorf("load() unexpected error: %v", err)
+			}
+			if tt.want != "" && (err == nil || !strings.Contains(err.Error(), tt.want)) {
+				t.Errorf("load() error = %v, want %q", err, tt.want)
+			}
+			if err == nil && len(loaded.EffectiveListeners()) != len(tt.listeners) {
+				t.Errorf("EffectiveListeners() = %d, want %d", len(loaded.EffectiveListeners()), len(tt.listeners))
+			}
+		})
+	}
+}
+
+func TestEffectiveListeners_Fallback(t *testing.T) {
+	cfg := defaultConfig()
+	listeners := cfg.EffectiveListeners()
+	if len(listeners) != 1 || listeners[0].Name != "default" || listeners[0].Port != cfg.Server.Port {
+		t.Fatalf("EffectiveListeners() = %+v, want the server as 'default'", listeners)
+	}
+	if listeners[0].CIDRules.RequiredPrefix != cfg.CIDRules.RequiredPrefix {
+		t.Error("fallback listener does not use the top-level cidrules")
+	}
+}
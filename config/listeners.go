@@ -0,0 +1,68 @@
+package config
+
+import (
+	"fmt"
+	"net"
+)
+
+// ListenerConfig is one inbound port with its own protocol and rules, for
+// upstream receivers whose account spaces overlap.
+type ListenerConfig struct {
+	ServerConfig `yaml:",inline"`
+	CIDRules     CIDRules `yaml:"cidrules"`
+	// Route sends every message from this listener through the named
+	// route, or "default" for all clients, instead of matching the routing
+	// table.
+	Route string `yaml:"route"`
+}
+
+// EffectiveListeners returns Listeners, or Server and CIDRules as a single
+// listener named "default" when no list is configured.
+func (c *Config) EffectiveListeners() []ListenerConfig {
+	if len(c.Listeners) > 0 {
+		return c.Listeners
+	}
+	listener := ListenerConfig{ServerConfig: c.Server, CIDRules: c.CIDRules}
+	if listener.Name == "" {
+		listener.Name = "default"
+	}
+	return []ListenerConfig{listener}
+}
+
+// validateListeners checks every listener and that names and addresses are
+// unique and routes exist.
+func validateListeners(listeners []ListenerConfig, routes []RouteConfig, queue QueueConfig) error {
+	knownRoute := map[string]bool{"default": true}
+	for _, r := range routes {
+		knownRoute[r.Name] = true
+	}
+
+	names := make(map[string]bool)
+	addresses := make(map[string]bool)
+	for i, l := range listeners {
+		if l.Name == "" || names[l.Name] {
+			return fmt.Errorf("listeners[%d]: name '%s' is empty or already used", i, l.Name)
+		}
+		names[l.Name] = true
+
+		address := net.JoinHostPort(l.Host, l.Port)
+		if addresses[address] {
+			return fmt.Errorf("listeners[%d]: address %s is already used", i, address)
+		}
+		addresses[address] = true
+
+		if err := l.ServerConfig.Validate(); err != nil {
+			return fmt.Errorf("listeners[%d]: %w", i, err)
+		}
+		if err := l.CIDRules.Validate(); err != nil {
+			return fmt.Errorf("listeners[%d]: %w", i, err)
+		}
+		if l.AckMode == AckStore && queue.Dir == "" {
+			return fmt.Errorf("listeners[%d]: ackmode '%s' requires queue.dir", i, AckStore)
+		}
+		if l.Route != "" && !knownRoute[l.Route] {
+			return fmt.Errorf("listeners[%d]: unknown route '%s'", i, l.Route)
+		}
+	}
+	return nil
+}
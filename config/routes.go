@@ -0,0 +1,67 @@
+package config
+
+import "fmt"
+
+// RouteConfig sends matching messages to a subset of the clients. Routes are
+// checked in order against the message as forwarded, after account
+// remapping; messages matching no route take the "default" route to every
+// client under the top-level fanout policy.
+type RouteConfig struct {
+	Name    string     `yaml:"name"`
+	Match   RouteMatch `yaml:"match"`
+	Clients []string   `yaml:"clients"`
+	// Ack and Primary decide the reply to the sender when Clients has more
+	// than one entry, as in FanoutConfig.
+	Fanout FanoutConfig `yaml:",inline"`
+}
+
+// RouteMatch extends MessageMatch with the receiver and line numbers of the
+// frame and a queue priority class name, e.g. "alarm".
+type RouteMatch struct {
+	MessageMatch `yaml:",inline"`
+	Receivers    Range  `yaml:"receivers"`
+	Lines        Range  `yaml:"lines"`
+	Class        string `yaml:"class"`
+}
+
+// validateRoutes checks route names, client references and classes.
+func validateRoutes(routes []RouteConfig, clients []ClientConfig, classes []PriorityClass) error {
+	byName := make(map[string]ClientConfig, len(clients))
+	for _, c := range clients {
+		byName[c.Name] = c
+	}
+	knownClass := map[string]bool{DefaultLane: true}
+	for _, p := range classes {
+		knownClass[p.Name] = true
+	}
+
+	seen := make(map[string]bool)
+	for i, r := range routes {
+		if r.Name == "" || r.Name == "default" || seen[r.Name] {
+			return fmt.Errorf("routes[%d]: name '%s' is empty, reserved or already used", i, r.Name)
+		}
+		seen[r.Name] = true
+
+		if err := r.Match.Validate(); err != nil {
+			return fmt.Errorf("routes[%d].match: %w", i, err)
+		}
+		if r.Match.Class != "" && !knownClass[r.Match.Class] {
+			return fmt.Errorf("routes[%d].match: unknown class '%s'", i, r.Match.Class)
+		}
+		if len(r.Clients) == 0 {
+			return fmt.Errorf("routes[%d]: clients must not be empty", i)
+		}
+		targets := make([]ClientConfig, 0, len(r.Clients))
+		for _, name := range r.Clients {
+			c, ok := byName[name]
+			if !ok {
+				return fmt.Errorf("routes[%d]: no client named '%s'", i, name)
+			}
+			targets = append(targets, c)
+		}
+		if err := r.Fanout.Validate(targets); err != nil {
+			return fmt.Errorf("routes[%d]: %w", i, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,262 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Range is an inclusive numeric range. In YAML it is written as "2000-2200"
+// or as a single value "1234". An empty range matches every value.
+type Range struct {
+	Min int
+	Max int
+	Set bool
+}
+
+// ParseRange parses the "min-max" or "value" form of a Range.
+func ParseRange(s string) (Range, error) {
+	s = strings.TrimSpace(s)
+	if s == "" || s == "*" {
+		return Range{}, nil
+	}
+
+	lo, hi, isRange := strings.Cut(s, "-")
+	min, err := strconv.Atoi(strings.TrimSpace(lo))
+	if err != nil {
+		return Range{}, fmt.Errorf("invalid range '%s': %w", s, err)
+	}
+	max := min
+	if isRange {
+		if max, err = strconv.Atoi(strings.TrimSpace(hi)); err != nil {
+			return Range{}, fmt.Errorf("invalid range '%s': %w", s, err)
+		}
+	}
+	if min < 0 || max < min {
+		return Range{}, fmt.Errorf("invalid range '%s': bounds must be non-negative and ordered", s)
+	}
+	return Range{Min: min, Max: max, Set: true}, nil
+}
+
+// Contains reports whether n lies within the range.
+func (r Range) Contains(n int) bool {
+	if !r.Set {
+		return true
+	}
+	return n >= r.Min && n <= r.Max
+}
+
+func (r Range) String() string {
+	switch {
+	case !r.Set:
+		return ""
+	case r.Min == r.Max:
+		return strconv.Itoa(r.Min)
+	default:
+		return fmt.Sprintf("%d-%d", r.Min, r.Max)
+	}
+}
+
+// MarshalYAML writes the range in its short string form.
+func (r Range) MarshalYAML() (interface{}, error) {
+	return r.String(), nil
+}
+
+// UnmarshalYAML accepts both "2000-2200" and plain integer values.
+func (r *Range) UnmarshalYAML(value *yaml.Node) error {
+	parsed, err := ParseRange(value.Value)
+	if err != nil {
+		return err
+	}
+	*r = parsed
+	return nil
+}
+
+// Account remapping actions.
+const (
+	AccountActionAdd      = "add"
+	AccountActionSubtract = "subtract"
+	AccountActionReplace  = "replace"
+	AccountActionPass     = "pass"
+)
+
+// MaxAccount is the largest account that fits the 4-digit Contact ID field.
+const MaxAccount = 9999
+
+// AccountRule rewrites accounts that fall into Accounts. Rules are evaluated
+// in order and the first matching rule wins.
+type AccountRule struct {
+	Accounts Range  `yaml:"accounts"`
+	Action   string `yaml:"action"`
+	Value    int    `yaml:"value"`
+}
+
+// Validate checks that the rule is well formed and that every account it can
+// produce still fits the 4-digit account field.
+func (r AccountRule) Validate() error {
+	lo, hi := r.Accounts.Min, r.Accounts.Max
+	if !r.Accounts.Set {
+		lo, hi = 0, MaxAccount
+	}
+
+	switch r.Action {
+	case AccountActionAdd:
+		lo, hi = lo+r.Value, hi+r.Value
+	case AccountActionSubtract:
+		lo, hi = lo-r.Value, hi-r.Value
+	case AccountActionReplace:
+		lo, hi = r.Value, r.Value
+	case AccountActionPass:
+	default:
+		return fmt.Errorf("unknown account action '%s'", r.Action)
+	}
+
+	if lo < 0 || hi > MaxAccount {
+		return fmt.Errorf("%s %d on accounts '%s' produces accounts outside 0-%d", r.Action, r.Value, r.Accounts, MaxAccount)
+	}
+	return nil
+}
+
+// EffectiveAccountRules returns the configured account rules, or the legacy
+// rule (accounts 2000-2200 plus AccNumAdd) when none are configured.
+func (r *CIDRules) EffectiveAccountRules() []AccountRule {
+	if len(r.AccountRules) > 0 {
+		return r.AccountRules
+	}
+	return []AccountRule{{
+		Accounts: Range{Min: 2000, Max: 2200, Set: true},
+		Action:   AccountActionAdd,
+		Value:    r.AccNumAdd,
+	}}
+}
+
+// Policies for accounts missing from the account map.
+const (
+	UnknownAccountPass    = "pass"
+	UnknownAccountReject  = "reject"
+	UnknownAccountDefault = "default"
+)
+
+// Validate checks the account map settings.
+func (m AccountMapConfig) Validate() error {
+	switch m.Unknown {
+	case "", UnknownAccountPass, UnknownAccountReject:
+	case UnknownAccountDefault:
+		if m.DefaultAccount < 0 || m.DefaultAccount > MaxAccount {
+			return fmt.Errorf("default account %d outside 0-%d", m.DefaultAccount, MaxAccount)
+		}
+	default:
+		return fmt.Errorf("unknown account policy '%s'", m.Unknown)
+	}
+	return nil
+}
+
+// MessageMatch selects messages by their Contact ID fields. Empty fields match
+// every message.
+type MessageMatch struct {
+	Accounts  Range  `yaml:"accounts"`
+	Codes     Range  `yaml:"codes"`
+	Qualifier string `yaml:"qualifier"`
+	Groups    Range  `yaml:"groups"`
+	Zones     Range  `yaml:"zones"`
+}
+
+// Validate checks the match for configuration errors.
+func (m MessageMatch) Validate() error {
+	return validateQualifier(m.Qualifier)
+}
+
+// CodeRewrite holds replacement values for a message. Unset fields are left unchanged.
+type CodeRewrite struct {
+	Qualifier string `yaml:"qualifier,omitempty"`
+	Code      *int   `yaml:"code,omitempty"`
+	Zone      *int   `yaml:"zone,omitempty"`
+}
+
+// CodeRule rewrites the qualifier, event code and zone of matching messages.
+// Rules match the message as received, before account remapping.
+type CodeRule struct {
+	Match MessageMatch `yaml:"match"`
+	Set   CodeRewrite  `yaml:"set"`
+}
+
+// Validate checks that the rule only produces values that fit the frame.
+func (r CodeRule) Validate() error {
+	if err := r.Match.Validate(); err != nil {
+		return err
+	}
+	if err := validateQualifier(r.Set.Qualifier); err != nil {
+		return err
+	}
+	if r.Set.Code != nil && (*r.Set.Code < 0 || *r.Set.Code > 999) {
+		return fmt.Errorf("event code %d outside 0-999", *r.Set.Code)
+	}
+	if r.Set.Zone != nil && (*r.Set.Zone < 0 || *r.Set.Zone > 999) {
+		return fmt.Errorf("zone %d outside 0-999", *r.Set.Zone)
+	}
+	return nil
+}
+
+// Filter actions.
+const (
+	FilterForward  = "forward"
+	FilterDropACK  = "drop-ack"
+	FilterDropNACK = "drop-nack"
+)
+
+// FilterRule decides what happens to matching messages. Filters match the
+// message as received, before any rewriting.
+type FilterRule struct {
+	Name   string       `yaml:"name"`
+	Match  MessageMatch `yaml:"match"`
+	Action string       `yaml:"action"`
+}
+
+// Validate checks the filter for configuration errors.
+func (f FilterRule) Validate() error {
+	switch f.Action {
+	case FilterForward, FilterDropACK, FilterDropNACK:
+	default:
+		return fmt.Errorf("unknown filter action '%s'", f.Action)
+	}
+	return f.Match.Validate()
+}
+
+func validateQualifier(q string) error {
+	switch q {
+	case "", "E", "R", "P":
+		return nil
+	}
+	return fmt.Errorf("invalid qualifier '%s', want E, R or P", q)
+}
+
+// Validate checks the CID rules for configuration errors.
+func (r *CIDRules) Validate() error {
+	for i, rule := range r.AccountRules {
+		if err := rule.Validate(); err != nil {
+			return fmt.Errorf("cidrules.accountrules[%d]: %w", i, err)
+		}
+	}
+	if len(r.AccountRules) == 0 {
+		// The legacy rule built from AccNumAdd must fit the account field too.
+		if err := r.EffectiveAccountRules()[0].Validate(); err != nil {
+			return fmt.Errorf("cidrules.accnumadd: %w", err)
+		}
+	}
+	if err := r.AccountMap.Validate(); err != nil {
+		return fmt.Errorf("cidrules.accountmap: %w", err)
+	}
+	for i, rule := range r.CodeRules {
+		if err := rule.Validate(); err != nil {
+			return fmt.Errorf("cidrules.coderules[%d]: %w", i, err)
+		}
+	}
+	for i, filter := range r.Filters {
+		if err := filter.Validate(); err != nil {
+			return fmt.Errorf("cidrules.filters[%d]: %w", i, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,133 @@
+package config
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"time"
+)
+
+// TLS versions accepted by TLSConfig.MinVersion.
+const (
+	TLS12 = "1.2"
+	TLS13 = "1.3"
+)
+
+// TLSConfig enables TLS on the listener or on the client connections.
+type TLSConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// CertFile and KeyFile hold the PEM certificate chain and key presented
+	// to the peer. Required on the listener; on the client they are only
+	// sent when the receiver asks for a client certificate.
+	CertFile string `yaml:"certfile"`
+	KeyFile  string `yaml:"keyfile"`
+	// CAFile is a PEM bundle for verifying the peer. The client uses the
+	// system roots when it is empty.
+	CAFile string `yaml:"cafile"`
+	// ClientAuth makes the listener require client certificates signed by
+	// CAFile (mutual TLS).
+	ClientAuth bool `yaml:"clientauth"`
+	// ServerName is the name the client verifies in the receiver's
+	// certificate. Empty means the target host.
+	ServerName string `yaml:"servername"`
+	// MinVersion is "1.2" (default) or "1.3".
+	MinVersion string `yaml:"minversion"`
+}
+
+// Validate checks the settings and that the files load. listener selects
+// the rules for ServerConfig.
+func (t TLSConfig) Validate(listener bool) error {
+	if !t.Enabled {
+		return nil
+	}
+	switch t.MinVersion {
+	case "", TLS12, TLS13:
+	default:
+		return fmt.Errorf("unknown minversion '%s'", t.MinVersion)
+	}
+	if listener && (t.CertFile == "" || t.KeyFile == "") {
+		return fmt.Errorf("certfile and keyfile are required")
+	}
+	if (t.CertFile == "") != (t.KeyFile == "") {
+		return fmt.Errorf("certfile and keyfile must be set together")
+	}
+	if t.ClientAuth && t.CAFile == "" {
+		return fmt.Errorf("clientauth requires cafile")
+	}
+	_, err := t.build()
+	return err
+}
+
+// ServerTLS returns the configuration for accepting connections.
+func (t TLSConfig) ServerTLS() (*tls.Config, error) {
+	cfg, err := t.build()
+	if err != nil {
+		return nil, err
+	}
+	cfg.ClientCAs, cfg.RootCAs = cfg.RootCAs, nil
+	if t.ClientAuth {
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return cfg, nil
+}
+
+// ClientTLS returns the configuration for dialing receivers. The caller
+// sets the target host as ServerName when it is empty.
+func (t TLSConfig) ClientTLS() (*tls.Config, error) {
+	cfg, err := t.build()
+	if err != nil {
+		return nil, err
+	}
+	cfg.ServerName = t.ServerName
+	return cfg, nil
+}
+
+// build loads the certificate and CA bundle shared by both sides.
+func (t TLSConfig) build() (*tls.Config, error) {
+	cfg := &tls.Config{MinVersion: tls.VersionTLS12}
+	if t.MinVersion == TLS13 {
+		cfg.MinVersion = tls.VersionTLS13
+	}
+
+	if t.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(t.CertFile, t.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("cannot load certificate: %w", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+
+	if t.CAFile != "" {
+		pem, err := os.ReadFile(t.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read CA bundle: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", t.CAFile)
+		}
+		cfg.RootCAs = pool
+	}
+	return cfg, nil
+}
+
+// CertExpiry returns when the first certificate of cfg expires, zero if it
+// has none.
+func CertExpiry(cfg *tls.Config) time.Time {
+	if cfg == nil || len(cfg.Certificates) == 0 {
+		return time.Time{}
+	}
+	cert := cfg.Certificates[0]
+	if cert.Leaf != nil {
+		return cert.Leaf.NotAfter
+	}
+	if len(cert.Certificate) == 0 {
+		return time.Time{}
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return time.Time{}
+	}
+	return leaf.NotAfter
+}
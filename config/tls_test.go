@@ -0,0 +1,230 @@
+package config
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testPKI writes a CA and certificates signed by it into a temp directory.
+type testPKI struct {
+	dir    string
+	ca     *x509.Certificate
+	caKey  *ecdsa.PrivateKey
+	serial int64
+}
+
+func newTestPKI(t *testing.T) *testPKI {
+	t.Helper()
+	p := &testPKI{dir: t.TempDir()}
+	p.ca, p.caKey = p.issue(t, "ca", &x509.Certificate{
+		Subject:               pkix.Name{CommonName: "Test CA"},
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}, time.Hour)
+	return p
+}
+
+// issue creates a certificate from tmpl valid for ttl and writes
+// <name>.crt and <name>.key. The CA signs itself when p.ca is nil.
+func (p *testPKI) issue(t *testing.T, name string, tmpl *x509.Certificate, ttl time.Duration) (*x509.Certificate, *ecdsa.PrivateKey) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.serial++
+	tmpl.SerialNumber = big.NewInt(p.serial)
+	tmpl.NotBefore = time.Now().Add(-time.Minute)
+	tmpl.NotAfter = time.Now().Add(ttl)
+
+	parent, signer := tmpl, key
+	if p.ca != nil {
+		parent, signer = p.ca, p.caKey
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, &key.PublicKey, signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	keyDER, _ := x509.MarshalECPrivateKey(key)
+
+	writePEM(t, filepath.Join(p.dir, name+".crt"), "CERTIFICATE", der)
+	writePEM(t, filepath.Join(p.dir, name+".key"), "EC PRIVATE KEY", keyDER)
+	return cert, key
+}
+
+func (p *testPKI) leaf(t *testing.T, name string, usage x509.ExtKeyUsage, ttl time.Duration) {
+	p.issue(t, name, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: name},
+		DNSNames:    []string{"localhost"},
+		IPAddresses: []net.IP{net.IPv4(127, 0, 0, 1)},
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{usage},
+	}, ttl)
+}
+
+func (p *testPKI) path(name string) string {
+	return filepath.Join(p.dir, name)
+}
+
+func writePEM(t *testing.T, path, typ string, der []byte) {
+	t.Helper()
+	data := pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der})
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTLSConfig_Validate(t *testing.T) {
+	pki := newTestPKI(t)
+	pki.leaf(t, "server", x509.ExtKeyUsageServerAuth, time.Hour)
+
+	tests := []struct {
+		name     string
+		cfg      TLSConfig
+		listener bool
+		want     string
+	}{
+		{"disabled", TLSConfig{CertFile: "missing"}, true, ""},
+		{"listener", TLSConfig{Enabled: true, CertFile: pki.path("server.crt"), KeyFile: pki.path("server.key")}, true, ""},
+		{"listener without cert", TLSConfig{Enabled: true}, true, "required"},
+		{"client without cert", TLSConfig{Enabled: true, CAFile: pki.path("ca.crt")}, false, ""},
+		{"key without cert", TLSConfig{Enabled: true, KeyFile: pki.path("server.key")}, false, "together"},
+		{"clientauth without ca", TLSConfig{Enabled: true, CertFile: pki.path("server.crt"), KeyFile: pki.path("server.key"), ClientAuth: true}, true, "cafile"},
+		{"bad version", TLSConfig{Enabled: true, MinVersion: "1.0"}, false, "minversion"},
+		{"missing ca", TLSConfig{Enabled: true, CAFile: pki.path("none.crt")}, false, "CA bundle"},
+		{"ca is not pem", TLSConfig{Enabled: true, CAFile: pki.path("server.key")}, false, "no certificates"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.cfg.Validate(tt.listener)
+			if tt.want == "" && err != nil {
+				t.Errorf("Validate() unexpected error: %v", err)
+			}
+			if tt.want != "" && (err == nil || !strings.Contains(err.Error(), tt.want)) {
+				t.Errorf("Validate() error = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+// handshake connects a client to a listener and returns the client's error.
+func handshake(t *testing.T, server, client TLSConfig) error {
+	t.Helper()
+	serverTLS, err := server.ServerTLS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientTLS, err := client.ClientTLS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if clientTLS.ServerName == "" {
+		clientTLS.ServerName = "localhost"
+	}
+
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", serverTLS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		if conn.(*tls.Conn).Handshake() == nil {
+			conn.Write([]byte{1})
+		}
+		conn.Close()
+	}()
+
+	conn, err := tls.Dial("tcp", ln.Addr().String(), clientTLS)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	// With TLS 1.3 a rejected client certificate shows up on the first read.
+	_, err = conn.Read(make([]byte, 1))
+	return err
+}
+
+func TestTLSConfig_Handshake(t *testing.T) {
+	pki := newTestPKI(t)
+	pki.leaf(t, "server", x509.ExtKeyUsageServerAuth, time.Hour)
+	pki.leaf(t, "client", x509.ExtKeyUsageClientAuth, time.Hour)
+	other := newTestPKI(t)
+
+	server := TLSConfig{Enabled: true, CertFile: pki.path("server.crt"), KeyFile: pki.path("server.key")}
+	mutual := server
+	mutual.CAFile = pki.path("ca.crt")
+	mutual.ClientAuth = true
+	client := TLSConfig{Enabled: true, CAFile: pki.path("ca.crt")}
+	withCert := client
+	withCert.CertFile = pki.path("client.crt")
+	withCert.KeyFile = pki.path("client.key")
+
+	tests := []struct {
+		name           string
+		server, client TLSConfig
+		ok             bool
+	}{
+		{"server only", server, client, true},
+		{"unknown ca", server, TLSConfig{Enabled: true, CAFile: other.path("ca.crt")}, false},
+		{"wrong server name", server, TLSConfig{Enabled: true, CAFile: pki.path("ca.crt"), ServerName: "example.com"}, false},
+		{"mutual", mutual, withCert, true},
+		{"mutual without client cert", mutual, client, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := handshake(t, tt.server, tt.client)
+			if tt.ok && err != nil {
+				t.Errorf("handshake failed: %v", err)
+			}
+			if !tt.ok && err == nil {
+				t.Error("handshake succeeded, want failure")
+			}
+		})
+	}
+}
+
+func TestTLSConfig_MinVersion(t *testing.T) {
+	cfg, err := TLSConfig{Enabled: true, MinVersion: TLS13}.ClientTLS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.MinVersion != tls.VersionTLS13 {
+		t.Errorf("MinVersion = %x, want TLS 1.3", cfg.MinVersion)
+	}
+}
+
+func TestCertExpiry(t *testing.T) {
+	pki := newTestPKI(t)
+	pki.leaf(t, "server", x509.ExtKeyUsageServerAuth, 48*time.Hour)
+
+	cfg, err := TLSConfig{Enabled: true, CertFile: pki.path("server.crt"), KeyFile: pki.path("server.key")}.ServerTLS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if left := time.Until(CertExpiry(cfg)); left < 47*time.Hour || left > 48*time.Hour {
+		t.Errorf("CertExpiry() in %s, want about 48h", left)
+	}
+	if !CertExpiry(nil).IsZero() {
+		t.Error("CertExpiry(nil) not zero")
+	}
+}
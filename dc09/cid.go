@@ -0,0 +1,169 @@
+package dc09
+
+import (
+	"cid_retranslator/cidParser"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// siaCodes maps common SIA-DCS event codes to Contact ID qualifier and event code.
+var siaCodes = map[string]struct {
+	qualifier cidparser.Qualifier
+	code      int
+}{
+	"MA": {cidparser.QualifierNew, 100}, "MH": {cidparser.QualifierRestore, 100},
+	"FA": {cidparser.QualifierNew, 110}, "FH": {cidparser.QualifierRestore, 110}, "FR": {cidparser.QualifierRestore, 110},
+	"PA": {cidparser.QualifierNew, 120}, "PH": {cidparser.QualifierRestore, 120}, "PR": {cidparser.QualifierRestore, 120},
+	"HA": {cidparser.QualifierNew, 122}, "HH": {cidparser.QualifierRestore, 122}, "HR": {cidparser.QualifierRestore, 122},
+	"BA": {cidparser.QualifierNew, 130}, "BH": {cidparser.QualifierRestore, 130}, "BR": {cidparser.QualifierRestore, 130},
+	"TA": {cidparser.QualifierNew, 137}, "TR": {cidparser.QualifierRestore, 137},
+	"GA": {cidparser.QualifierNew, 151}, "GH": {cidparser.QualifierRestore, 151},
+	"WA": {cidparser.QualifierNew, 154}, "WH": {cidparser.QualifierRestore, 154},
+	"KA": {cidparser.QualifierNew, 158}, "KH": {cidparser.QualifierRestore, 158},
+	"AT": {cidparser.QualifierNew, 301}, "AR": {cidparser.QualifierRestore, 301},
+	"YT": {cidparser.QualifierNew, 302}, "YR": {cidparser.QualifierRestore, 302},
+	"RR": {cidparser.QualifierNew, 305},
+	"LT": {cidparser.QualifierNew, 351}, "LR": {cidparser.QualifierRestore, 351},
+	"YC": {cidparser.QualifierNew, 354}, "YK": {cidparser.QualifierRestore, 354},
+	"FT": {cidparser.QualifierNew, 373}, "FJ": {cidparser.QualifierRestore, 373},
+	"UT": {cidparser.QualifierNew, 380}, "UJ": {cidparser.QualifierRestore, 380},
+	"XT": {cidparser.QualifierNew, 384}, "XR": {cidparser.QualifierRestore, 384},
+	"OP": {cidparser.QualifierNew, 401}, "CL": {cidparser.QualifierRestore, 401},
+	"OG": {cidparser.QualifierNew, 402}, "CG": {cidparser.QualifierRestore, 402},
+	"BB": {cidparser.QualifierNew, 570}, "BU": {cidparser.QualifierRestore, 570},
+	"RX": {cidparser.QualifierNew, 601},
+	"RP": {cidparser.QualifierNew, 602},
+}
+
+// admQualifiers maps ADM-CID qualifier digits to Contact ID qualifiers.
+var admQualifiers = map[byte]cidparser.Qualifier{
+	'1': cidparser.QualifierNew,
+	'3': cidparser.QualifierRestore,
+	'6': cidparser.QualifierPrevious,
+}
+
+// ContactID converts an ADM-CID or SIA-DCS frame into a Contact ID message
+// with format "18" and the given prefix. The account must be four decimal
+// digits, as Contact ID has no room for anything else.
+func (f *Frame) ContactID(prefix byte) (*cidparser.Message, error) {
+	receiver, err := hexField("receiver", f.Receiver, 99)
+	if err != nil {
+		return nil, err
+	}
+	line, err := hexField("line", f.Line, 9)
+	if err != nil {
+		return nil, err
+	}
+
+	account, event, ok := strings.Cut(f.Data, "|")
+	if !ok {
+		return nil, fmt.Errorf("missing '|' in data block '%s'", f.Data)
+	}
+	account = strings.TrimPrefix(account, "#")
+	if account == "" {
+		account = f.Account
+	}
+	if len(account) != 4 || strings.Trim(account, "0123456789") != "" {
+		return nil, fmt.Errorf("account '%s' is not a 4-digit Contact ID account", account)
+	}
+	acct, _ := strconv.Atoi(account)
+
+	msg := &cidparser.Message{Prefix: prefix, Receiver: receiver, Line: line, Format: "18", Account: acct}
+	switch f.ID {
+	case IDCID:
+		err = parseADM(msg, event)
+	case IDSIA:
+		err = parseSIA(msg, event)
+	default:
+		err = fmt.Errorf("message type '%s' carries no event", f.ID)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+// parseADM reads "QEEE GG CCC".
+func parseADM(msg *cidparser.Message, event string) error {
+	fields := strings.Fields(event)
+	if len(fields) != 3 || len(fields[0]) != 4 {
+		return fmt.Errorf("invalid ADM-CID event '%s'", event)
+	}
+	q, ok := admQualifiers[fields[0][0]]
+	if !ok {
+		return fmt.Errorf("invalid ADM-CID qualifier '%c'", fields[0][0])
+	}
+	msg.Qualifier = q
+
+	var err error
+	if msg.Code, err = strconv.Atoi(fields[0][1:]); err != nil {
+		return fmt.Errorf("invalid ADM-CID event code '%s'", fields[0][1:])
+	}
+	if msg.Group, err = strconv.Atoi(fields[1]); err != nil {
+		return fmt.Errorf("invalid ADM-CID group '%s'", fields[1])
+	}
+	if msg.Zone, err = strconv.Atoi(fields[2]); err != nil {
+		return fmt.Errorf("invalid ADM-CID zone '%s'", fields[2])
+	}
+	return nil
+}
+
+// parseSIA reads "Nri<area>/<CC><zone>" and uses the first event of the block.
+func parseSIA(msg *cidparser.Message, event string) error {
+	event = strings.TrimPrefix(event, "N")
+	for _, part := range strings.Split(event, "/") {
+		switch {
+		case strings.HasPrefix(part, "ri"):
+			area, err := strconv.Atoi(part[2:])
+			if err != nil {
+				return fmt.Errorf("invalid SIA area '%s'", part)
+			}
+			msg.Group = area
+		case strings.HasPrefix(part, "id") || strings.HasPrefix(part, "ti") || strings.HasPrefix(part, "pi"):
+			// user id, time and peripheral modifiers are not represented in Contact ID
+		case len(part) >= 2:
+			mapped, ok := siaCodes[part[:2]]
+			if !ok {
+				return fmt.Errorf("unsupported SIA event code '%s'", part[:2])
+			}
+			msg.Qualifier = mapped.qualifier
+			msg.Code = mapped.code
+			if zone := part[2:]; zone != "" {
+				z, err := strconv.Atoi(zone)
+				if err != nil {
+					return fmt.Errorf("invalid SIA zone '%s'", zone)
+				}
+				msg.Zone = z
+			}
+			return nil
+		}
+	}
+	return fmt.Errorf("no event code in SIA data '%s'", event)
+}
+
+func hexField(name, value string, max int) (int, error) {
+	if value == "" {
+		return 0, nil
+	}
+	n, err := strconv.ParseUint(value, 16, 32)
+	if err != nil || int(n) > max {
+		return 0, fmt.Errorf("%s '%s' does not fit the Contact ID frame", name, value)
+	}
+	return int(n), nil
+}
+
+// ADMData renders a Contact ID message as an ADM-CID data block, e.g.
+// "#1234|1602 00 000".
+func ADMData(msg *cidparser.Message) (string, error) {
+	var q byte
+	for digit, qualifier := range admQualifiers {
+		if qualifier == msg.Qualifier {
+			q = digit
+		}
+	}
+	if q == 0 {
+		return "", fmt.Errorf("invalid qualifier %q", byte(msg.Qualifier))
+	}
+	return fmt.Sprintf("#%04d|%c%03d %02d %03d", msg.Account, q, msg.Code, msg.Group, msg.Zone), nil
+}
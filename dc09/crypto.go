@@ -0,0 +1,135 @@
+package dc09
+
+import (
+	"cid_retranslator/config"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ErrDecrypt is returned when a payload cannot be decrypted, usually because
+// sender and receiver use different keys.
+var ErrDecrypt = errors.New("cannot decrypt DC-09 payload")
+
+const padAlphabet = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789"
+
+// Encrypt replaces the data block and timestamp with AES-CBC ciphertext and
+// marks the frame as encrypted. A zero Timestamp is set to the current time,
+// as encrypted frames must carry one.
+func (f *Frame) Encrypt(key []byte) error {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return err
+	}
+	if f.Timestamp.IsZero() {
+		f.Timestamp = time.Now()
+	}
+
+	// The opening '[' is replaced by random padding and a '|' so that the
+	// plaintext fills whole AES blocks.
+	content := f.Data + "]" + FormatTimestamp(f.Timestamp)
+	padLen := (aes.BlockSize - (len(content)+1)%aes.BlockSize) % aes.BlockSize
+	pad, err := randomPad(padLen)
+	if err != nil {
+		return err
+	}
+	plaintext := []byte(pad + "|" + content)
+
+	ciphertext := make([]byte, len(plaintext))
+	cipher.NewCBCEncrypter(block, make([]byte, aes.BlockSize)).CryptBlocks(ciphertext, plaintext)
+
+	f.Data = strings.ToUpper(hex.EncodeToString(ciphertext))
+	f.Encrypted = true
+	return nil
+}
+
+// Decrypt restores the data block and timestamp of an encrypted frame and
+// clears its Encrypted flag.
+func (f *Frame) Decrypt(key []byte) error {
+	if !f.Encrypted {
+		return nil
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return err
+	}
+
+	ciphertext, err := hex.DecodeString(f.Data)
+	if err != nil || len(ciphertext) == 0 || len(ciphertext)%aes.BlockSize != 0 {
+		return fmt.Errorf("%w: malformed ciphertext", ErrDecrypt)
+	}
+	plaintext := make([]byte, len(ciphertext))
+	cipher.NewCBCDecrypter(block, make([]byte, aes.BlockSize)).CryptBlocks(plaintext, ciphertext)
+
+	_, content, ok := strings.Cut(string(plaintext), "|")
+	if !ok {
+		return fmt.Errorf("%w: missing pad separator", ErrDecrypt)
+	}
+	decrypted := &Frame{}
+	if err := decrypted.parsePayload("[" + content); err != nil {
+		return fmt.Errorf("%w: %v", ErrDecrypt, err)
+	}
+	if decrypted.Timestamp.IsZero() {
+		return fmt.Errorf("%w: missing timestamp", ErrDecrypt)
+	}
+
+	f.Data = decrypted.Data
+	f.Timestamp = decrypted.Timestamp
+	f.Encrypted = false
+	return nil
+}
+
+// ParseKey decodes a hex AES-128, AES-192 or AES-256 key.
+func ParseKey(s string) ([]byte, error) {
+	key, err := hex.DecodeString(s)
+	if err != nil {
+		return nil, errors.New("key is not valid hex")
+	}
+	switch len(key) {
+	case 16, 24, 32:
+		return key, nil
+	}
+	return nil, fmt.Errorf("key must be 16, 24 or 32 bytes, got %d", len(key))
+}
+
+func randomPad(n int) (string, error) {
+	buf := make([]byte, n)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	for i, b := range buf {
+		buf[i] = padAlphabet[int(b)%len(padAlphabet)]
+	}
+	return string(buf), nil
+}
+
+// Keyring maps DC-09 accounts to AES keys. The "*" entry applies to every
+// account without a key of its own.
+type Keyring map[string][]byte
+
+// NewKeyring decodes the configured keys.
+func NewKeyring(keys []config.DC09Key) (Keyring, error) {
+	ring := make(Keyring, len(keys))
+	for _, k := range keys {
+		key, err := ParseKey(string(k.Key))
+		if err != nil {
+			return nil, fmt.Errorf("account %s: %w", k.Account, err)
+		}
+		ring[k.Account] = key
+	}
+	return ring, nil
+}
+
+// Lookup returns the key for the account.
+func (k Keyring) Lookup(account string) ([]byte, bool) {
+	if key, ok := k[account]; ok {
+		return key, true
+	}
+	key, ok := k["*"]
+	return key, ok
+}
@@ -0,0 +1,287 @@
+// Package dc09 implements SIA DC-09 framing used by IP alarm communicators.
+package dc09
+
+import (
+	"bufio"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Message identifiers.
+const (
+	IDSIA    = "SIA-DCS"
+	IDCID    = "ADM-CID"
+	IDNull   = "NULL"
+	IDACK    = "ACK"
+	IDNAK    = "NAK"
+	IDDUH    = "DUH"
+	maxFrame = 2048
+)
+
+// DC-09 timestamps look like "_HH:MM:SS,MM-DD-YYYY" and are always in UTC.
+// The comma would be read as a fractional second separator by time.Parse,
+// so both halves are handled separately.
+const (
+	timeLayout = "15:04:05"
+	dateLayout = "01-02-2006"
+)
+
+// FormatTimestamp renders t as a DC-09 timestamp.
+func FormatTimestamp(t time.Time) string {
+	t = t.UTC()
+	return "_" + t.Format(timeLayout) + "," + t.Format(dateLayout)
+}
+
+// ParseTimestamp parses a DC-09 timestamp including its leading '_'.
+func ParseTimestamp(s string) (time.Time, error) {
+	clock, date, ok := strings.Cut(strings.TrimPrefix(s, "_"), ",")
+	if !ok || !strings.HasPrefix(s, "_") {
+		return time.Time{}, fmt.Errorf("invalid timestamp '%s'", s)
+	}
+	t, err := time.Parse(dateLayout+" "+timeLayout, date+" "+clock)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid timestamp '%s'", s)
+	}
+	return t, nil
+}
+
+// Frame is a decoded DC-09 message.
+type Frame struct {
+	ID        string
+	Encrypted bool
+	Sequence  int
+	Receiver  string // hex receiver number, empty when absent
+	Line      string // hex line prefix
+	Account   string // hex account from the header
+	// Data is the content between the first pair of brackets. For encrypted
+	// frames it holds the hex ciphertext until the frame is decrypted.
+	Data      string
+	Timestamp time.Time
+}
+
+// CRC16 computes the CRC-16/ARC checksum used by DC-09.
+func CRC16(data []byte) uint16 {
+	var crc uint16
+	for _, b := range data {
+		crc ^= uint16(b)
+		for i := 0; i < 8; i++ {
+			if crc&1 != 0 {
+				crc = crc>>1 ^ 0xA001
+			} else {
+				crc >>= 1
+			}
+		}
+	}
+	return crc
+}
+
+// ReadFrame reads one LF...CR delimited frame from r, discarding any bytes
+// before the LF. A frame is rejected as soon as it grows beyond maxFrame, so
+// a peer that never sends CR cannot make it buffer without limit.
+func ReadFrame(r *bufio.Reader) ([]byte, error) {
+	for {
+		_, err := r.ReadSlice('\n')
+		if err == nil {
+			break
+		}
+		if err != bufio.ErrBufferFull {
+			return nil, err
+		}
+	}
+
+	frame := []byte{'\n'}
+	for {
+		chunk, err := r.ReadSlice('\r')
+		if len(frame)-1+len(chunk) > maxFrame {
+			return nil, fmt.Errorf("frame longer than %d bytes", maxFrame)
+		}
+		frame = append(frame, chunk...)
+		if err == nil {
+			return frame, nil
+		}
+		if err != bufio.ErrBufferFull {
+			return nil, err
+		}
+	}
+}
+
+// Decode parses a complete frame including the LF and CR delimiters and
+// verifies its length and CRC.
+func Decode(raw []byte) (*Frame, error) {
+	s := string(raw)
+	s = strings.TrimPrefix(s, "\n")
+	s = strings.TrimSuffix(s, "\r")
+	if len(s) < 8 {
+		return nil, fmt.Errorf("frame too short: %d bytes", len(s))
+	}
+
+	crc, err := strconv.ParseUint(s[:4], 16, 16)
+	if err != nil {
+		return nil, fmt.Errorf("invalid CRC field '%s'", s[:4])
+	}
+	length, err := strconv.ParseUint(s[4:8], 16, 16)
+	if err != nil {
+		return nil, fmt.Errorf("invalid length field '%s'", s[4:8])
+	}
+	body := s[8:]
+	if int(length) != len(body) {
+		return nil, fmt.Errorf("length mismatch: header %d, body %d", length, len(body))
+	}
+	if got := CRC16([]byte(body)); got != uint16(crc) {
+		return nil, fmt.Errorf("CRC mismatch: header %04X, computed %04X", crc, got)
+	}
+	return parseBody(body)
+}
+
+func parseBody(body string) (*Frame, error) {
+	if len(body) == 0 || body[0] != '"' {
+		return nil, fmt.Errorf("missing message ID")
+	}
+	end := strings.IndexByte(body[1:], '"')
+	if end < 0 {
+		return nil, fmt.Errorf("unterminated message ID")
+	}
+	f := &Frame{ID: body[1 : end+1]}
+	if strings.HasPrefix(f.ID, "*") {
+		f.Encrypted = true
+		f.ID = f.ID[1:]
+	}
+	rest := body[end+2:]
+
+	if len(rest) < 4 {
+		return nil, fmt.Errorf("missing sequence number")
+	}
+	seq, err := strconv.Atoi(rest[:4])
+	if err != nil {
+		return nil, fmt.Errorf("invalid sequence number '%s'", rest[:4])
+	}
+	f.Sequence = seq
+	rest = rest[4:]
+
+	// Routing fields: Rrcvr, Lpref and #acct. NAK frames carry the fixed
+	// "R0L0A0" which is ambiguous with hex values, so it is skipped.
+	if f.ID == IDNAK {
+		if i := strings.IndexByte(rest, '['); i >= 0 {
+			rest = rest[i:]
+		}
+	}
+	for len(rest) > 0 && rest[0] != '[' {
+		marker := rest[0]
+		n := 1
+		for n < len(rest) && isHex(rest[n]) {
+			n++
+		}
+		value := rest[1:n]
+		switch marker {
+		case 'R':
+			f.Receiver = value
+		case 'L':
+			f.Line = value
+		case '#':
+			f.Account = value
+		default:
+			return nil, fmt.Errorf("unexpected header field %q", marker)
+		}
+		rest = rest[n:]
+	}
+
+	if f.Encrypted {
+		// Data, extended data and timestamp are all part of the ciphertext.
+		if !strings.HasPrefix(rest, "[") {
+			return nil, fmt.Errorf("missing data block")
+		}
+		f.Data = rest[1:]
+		return f, nil
+	}
+	return f, f.parsePayload(rest)
+}
+
+// parsePayload reads "[data][x...]_timestamp" into the frame.
+func (f *Frame) parsePayload(rest string) error {
+	if !strings.HasPrefix(rest, "[") {
+		return fmt.Errorf("missing data block")
+	}
+	end := strings.IndexByte(rest, ']')
+	if end < 0 {
+		return fmt.Errorf("unterminated data block")
+	}
+	f.Data = rest[1:end]
+	rest = rest[end+1:]
+
+	// Extended data blocks are accepted but not used.
+	for strings.HasPrefix(rest, "[") {
+		end := strings.IndexByte(rest, ']')
+		if end < 0 {
+			return fmt.Errorf("unterminated extended data block")
+		}
+		rest = rest[end+1:]
+	}
+
+	if rest == "" {
+		return nil
+	}
+	ts, err := ParseTimestamp(rest)
+	if err != nil {
+		return err
+	}
+	f.Timestamp = ts
+	return nil
+}
+
+// Encode renders the frame with length, CRC and delimiters. A zero
+// Timestamp is omitted.
+func (f *Frame) Encode() []byte {
+	var b strings.Builder
+	b.WriteByte('"')
+	if f.Encrypted {
+		b.WriteByte('*')
+	}
+	b.WriteString(f.ID)
+	b.WriteByte('"')
+	fmt.Fprintf(&b, "%04d", f.Sequence)
+	if f.Receiver != "" {
+		b.WriteString("R" + f.Receiver)
+	}
+	b.WriteString("L" + f.Line)
+	if f.ID == IDNAK {
+		b.WriteString("A" + f.Account)
+	} else {
+		b.WriteString("#" + f.Account)
+	}
+	if f.Encrypted {
+		b.WriteString("[" + f.Data)
+	} else {
+		b.WriteString("[" + f.Data + "]")
+		if !f.Timestamp.IsZero() {
+			b.WriteString(FormatTimestamp(f.Timestamp))
+		}
+	}
+	return wrap(b.String())
+}
+
+func wrap(body string) []byte {
+	return []byte(fmt.Sprintf("\n%04X%04X%s\r", CRC16([]byte(body)), len(body), body))
+}
+
+// Reply builds an unencrypted ACK or DUH response to f.
+func (f *Frame) Reply(id string) *Frame {
+	return &Frame{
+		ID:        id,
+		Sequence:  f.Sequence,
+		Receiver:  f.Receiver,
+		Line:      f.Line,
+		Account:   f.Account,
+		Timestamp: time.Now(),
+	}
+}
+
+// NAK builds the generic negative acknowledgement carrying the receiver time.
+func NAK() *Frame {
+	return &Frame{ID: IDNAK, Receiver: "0", Line: "0", Account: "0", Timestamp: time.Now()}
+}
+
+func isHex(c byte) bool {
+	return c >= '0' && c <= '9' || c >= 'A' && c <= 'F' || c >= 'a' && c <= 'f'
+}
@@ -0,0 +1,252 @@
+package dc09
+
+import (
+	"bufio"
+	"cid_retranslator/cidParser"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCRC16(t *testing.T) {
+	if got := CRC16([]byte("123456789")); got != 0xBB3D {
+		t.Errorf("CRC16() = %04X, want BB3D", got)
+	}
+}
+
+func TestDecode(t *testing.T) {
+	body := `"ADM-CID"0042R1L2#1234[#1234|1602 00 000]_11:10:00,10-12-2025`
+	raw := wrap(body)
+
+	f, err := Decode(raw)
+	if err != nil {
+		t.Fatalf("Decode() unexpected error: %v", err)
+	}
+	if f.ID != IDCID || f.Sequence != 42 || f.Receiver != "1" || f.Line != "2" || f.Account != "1234" {
+		t.Errorf("Decode() header = %+v", f)
+	}
+	if f.Data != "#1234|1602 00 000" {
+		t.Errorf("Decode() data = %q", f.Data)
+	}
+	want := time.Date(2025, 10, 12, 11, 10, 0, 0, time.UTC)
+	if !f.Timestamp.Equal(want) {
+		t.Errorf("Decode() timestamp = %v, want %v", f.Timestamp, want)
+	}
+
+	if string(f.Encode()) != string(raw) {
+		t.Errorf("Encode() = %q, want %q", f.Encode(), raw)
+	}
+}
+
+func TestDecode_Errors(t *testing.T) {
+	good := wrap(`"NULL"0001L0#0[]`)
+
+	badCRC := []byte(string(good))
+	badCRC[1] = 'F'
+	if badCRC[1] == good[1] {
+		badCRC[1] = '0'
+	}
+
+	tests := []struct {
+		name string
+		raw  []byte
+		want string
+	}{
+		{"bad crc", badCRC, "CRC mismatch"},
+		{"bad length", []byte("\n00000099\"NULL\"0001L0#0[]\r"), "length mismatch"},
+		{"short", []byte("\n0000\r"), "frame too short"},
+		{"no id", wrap(`NULL0001L0#0[]`), "missing message ID"},
+		{"bad timestamp", wrap(`"NULL"0001L0#0[]_bogus`), "invalid timestamp"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Decode(tt.raw)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Decode() error = %v, want containing %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecode_NAK(t *testing.T) {
+	f, err := Decode(NAK().Encode())
+	if err != nil {
+		t.Fatalf("Decode(NAK) unexpected error: %v", err)
+	}
+	if f.ID != IDNAK || f.Timestamp.IsZero() {
+		t.Errorf("Decode(NAK) = %+v", f)
+	}
+}
+
+func TestReadFrame(t *testing.T) {
+	first := wrap(`"NULL"0001L0#1234[]`)
+	second := wrap(`"NULL"0002L0#1234[]`)
+	r := bufio.NewReader(strings.NewReader("noise" + string(first) + string(second)))
+
+	for _, want := range [][]byte{first, second} {
+		got, err := ReadFrame(r)
+		if err != nil {
+			t.Fatalf("ReadFrame() unexpected error: %v", err)
+		}
+		if string(got) != string(want) {
+			t.Errorf("ReadFrame() = %q, want %q", got, want)
+		}
+	}
+}
+
+// endless yields the same byte forever, like a peer that never sends CR.
+type endless byte
+
+func (e endless) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = byte(e)
+	}
+	return len(p), nil
+}
+
+func TestReadFrame_TooLong(t *testing.T) {
+	r := bufio.NewReader(io.MultiReader(strings.NewReader("\n"), endless('A')))
+	if _, err := ReadFrame(r); err == nil || !strings.Contains(err.Error(), "longer than") {
+		t.Errorf("ReadFrame() error = %v, want frame too long", err)
+	}
+
+	// The largest frame allowed still passes.
+	body := strings.Repeat("A", maxFrame-1) + "\r"
+	r = bufio.NewReader(strings.NewReader("\n" + body))
+	if got, err := ReadFrame(r); err != nil || len(got) != maxFrame+1 {
+		t.Errorf("ReadFrame() = %d bytes, %v; want %d", len(got), err, maxFrame+1)
+	}
+}
+
+func TestFrame_ContactID(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want string
+	}{
+		{"adm-cid", `"ADM-CID"0001R1L2#1234[#1234|1401 01 003]`, "5012 181234E40101003\x14"},
+		{"adm-cid restore", `"ADM-CID"0001L0#0042[#0042|3130 02 015]`, "5000 180042R13002015\x14"},
+		{"account 5000", `"ADM-CID"0001L0#5000[#5000|1130 01 001]`, "5000 185000E13001001\x14"},
+		{"account from header", `"ADM-CID"0001L0#9999[|1130 01 001]`, "5000 189999E13001001\x14"},
+		{"sia-dcs with area", `"SIA-DCS"0001L1#1234[#1234|Nri2/BA05]`, "5001 181234E13002005\x14"},
+		{"sia-dcs opening", `"SIA-DCS"0001L1#1234[#1234|NOP07]`, "5001 181234E40100007\x14"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := Decode(wrap(tt.body))
+			if err != nil {
+				t.Fatalf("Decode() unexpected error: %v", err)
+			}
+			msg, err := f.ContactID('5')
+			if err != nil {
+				t.Fatalf("ContactID() unexpected error: %v", err)
+			}
+			frame, err := msg.Encode()
+			if err != nil {
+				t.Fatalf("Encode() unexpected error: %v", err)
+			}
+			if string(frame) != tt.want {
+				t.Errorf("ContactID() = %q, want %q", frame, tt.want)
+			}
+		})
+	}
+}
+
+func TestFrame_ContactIDErrors(t *testing.T) {
+	tests := []string{
+		`"SIA-DCS"0001L1#1234[#1234|NZZ01]`,
+		`"ADM-CID"0001L1#12345[#12345|1130 01 001]`,
+		`"ADM-CID"0001L1#1234[#12G4|1130 01 001]`,
+		`"ADM-CID"0001L1#1A2B[#1A2B|1130 01 001]`,
+		`"ADM-CID"0001L1#123[#123|1130 01 001]`,
+		`"ADM-CID"0001L1#1234[#1234|9130 01 001]`,
+		`"NULL"0001L1#1234[]`,
+	}
+
+	for _, body := range tests {
+		f, err := Decode(wrap(body))
+		if err != nil {
+			t.Fatalf("Decode(%s) unexpected error: %v", body, err)
+		}
+		if _, err := f.ContactID('5'); err == nil {
+			t.Errorf("ContactID(%s) expected an error", body)
+		}
+	}
+}
+
+func TestADMData_RoundTrip(t *testing.T) {
+	msg, err := cidparser.Parse([]byte("5012 181234R40101003\x14"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ADMData(msg)
+	if err != nil {
+		t.Fatalf("ADMData() unexpected error: %v", err)
+	}
+	if data != "#1234|3401 01 003" {
+		t.Errorf("ADMData() = %q", data)
+	}
+
+	f := &Frame{ID: IDCID, Receiver: "1", Line: "2", Account: "1234", Data: data}
+	back, err := f.ContactID('5')
+	if err != nil {
+		t.Fatalf("ContactID() unexpected error: %v", err)
+	}
+	if *back != *msg {
+		t.Errorf("round trip = %+v, want %+v", *back, *msg)
+	}
+}
+
+func TestFrame_EncryptDecrypt(t *testing.T) {
+	for _, size := range []int{16, 24, 32} {
+		key := make([]byte, size)
+		for i := range key {
+			key[i] = byte(i)
+		}
+		ts := time.Date(2025, 3, 1, 8, 30, 0, 0, time.UTC)
+
+		f := &Frame{ID: IDCID, Sequence: 5, Line: "1", Account: "1234", Data: "#1234|1130 01 005", Timestamp: ts}
+		if err := f.Encrypt(key); err != nil {
+			t.Fatalf("Encrypt() unexpected error: %v", err)
+		}
+		raw := f.Encode()
+		if strings.Contains(string(raw), "1130") {
+			t.Fatalf("encrypted frame leaks plaintext: %q", raw)
+		}
+
+		decoded, err := Decode(raw)
+		if err != nil {
+			t.Fatalf("Decode() unexpected error: %v", err)
+		}
+		if !decoded.Encrypted || decoded.ID != IDCID || decoded.Account != "1234" {
+			t.Fatalf("Decode() header = %+v", decoded)
+		}
+		if err := decoded.Decrypt(key); err != nil {
+			t.Fatalf("Decrypt() unexpected error: %v", err)
+		}
+		if decoded.Data != "#1234|1130 01 005" || !decoded.Timestamp.Equal(ts) || decoded.Encrypted {
+			t.Errorf("Decrypt() = %+v", decoded)
+		}
+	}
+}
+
+func TestFrame_DecryptWrongKey(t *testing.T) {
+	right, wrong := make([]byte, 16), make([]byte, 16)
+	wrong[0] = 1
+
+	f := &Frame{ID: IDSIA, Sequence: 1, Line: "0", Account: "1234", Data: "#1234|NBA01"}
+	if err := f.Encrypt(right); err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := Decode(f.Encode())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := decoded.Decrypt(wrong); !errors.Is(err, ErrDecrypt) {
+		t.Errorf("Decrypt() with wrong key error = %v, want ErrDecrypt", err)
+	}
+}
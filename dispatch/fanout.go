@@ -0,0 +1,216 @@
+// Package dispatch distributes messages from the listener to the queues of
+// one or more downstream clients.
+package dispatch
+
+import (
+	"cid_retranslator/config"
+	"cid_retranslator/queue"
+	"errors"
+	"fmt"
+	"log/slog"
+)
+
+// Member is one downstream client reachable through its queue.
+type Member struct {
+	Name  string
+	Queue queue.Dispatcher
+}
+
+// Fanout delivers every message to all members and combines their results
+// according to an ACK policy.
+type Fanout struct {
+	members []Member
+	policy  string
+	primary int
+}
+
+// NewFanout creates a Fanout. primary names the member whose result counts
+// for the "primary" policy.
+func NewFanout(members []Member, policy, primary string) (*Fanout, error) {
+	if len(members) == 0 {
+		return nil, errors.New("fan-out needs at least one client")
+	}
+	f := &Fanout{members: members, policy: policy, primary: -1}
+	if f.policy == "" {
+		f.policy = config.FanoutAll
+	}
+	if f.policy == config.FanoutPrimary {
+		for i, m := range members {
+			if m.Name == primary {
+				f.primary = i
+			}
+		}
+		if f.primary < 0 {
+			return nil, fmt.Errorf("fan-out primary '%s' is not a client", primary)
+		}
+	}
+	return f, nil
+}
+
+type memberResult struct {
+	index int
+	reply queue.DeliveryData
+}
+
+// Dispatch queues the payload for every member. It fails if the policy
+// cannot be met by the members that stored the message.
+func (f *Fanout) Dispatch(payload []byte, wait bool) (<-chan queue.DeliveryData, error) {
+	if len(f.members) == 1 {
+		return f.members[0].Queue.Dispatch(payload, wait)
+	}
+
+	if reservers, ok := f.reservers(); ok {
+		held, err := f.store(payload, wait, reservers)
+		if err != nil || !wait {
+			return nil, err
+		}
+		results := make(chan memberResult, len(f.members))
+		for i, res := range held {
+			if res == nil {
+				results <- memberResult{index: i, reply: queue.DeliveryData{Status: false}}
+				continue
+			}
+			go await(i, res.ReplyCh(), results)
+		}
+		return f.combine(results), nil
+	}
+
+	results := make(chan memberResult, len(f.members))
+	var firstErr error
+	for i, m := range f.members {
+		replyCh, err := m.Queue.Dispatch(payload, wait)
+		if err != nil {
+			slog.Warn("Cannot queue message for client", "client", m.Name, "error", err)
+			if firstErr == nil {
+				firstErr = fmt.Errorf("client %s: %w", m.Name, err)
+			}
+			results <- memberResult{index: i, reply: queue.DeliveryData{Status: false, Response: err.Error()}}
+			continue
+		}
+		if !wait {
+			results <- memberResult{index: i, reply: queue.DeliveryData{Status: true, Response: "stored"}}
+			continue
+		}
+		go await(i, replyCh, results)
+	}
+
+	if !wait {
+		if reply := f.decide(results); !reply.Status {
+			return nil, firstErr
+		}
+		return nil, nil
+	}
+	return f.combine(results), nil
+}
+
+// await passes the reply of member i on to results.
+func await(i int, replyCh <-chan queue.DeliveryData, results chan<- memberResult) {
+	reply, ok := <-replyCh
+	if !ok {
+		reply = queue.DeliveryData{Status: false}
+	}
+	results <- memberResult{index: i, reply: reply}
+}
+
+// combine returns a channel that delivers the policy's answer once the
+// member results allow one.
+func (f *Fanout) combine(results <-chan memberResult) <-chan queue.DeliveryData {
+	combined := make(chan queue.DeliveryData, 1)
+	go func() {
+		combined <- f.decide(results)
+		close(combined)
+	}()
+	return combined
+}
+
+// reservers returns the member queues if all of them can reserve room for a
+// message.
+func (f *Fanout) reservers() ([]queue.Reserver, bool) {
+	reservers := make([]queue.Reserver, len(f.members))
+	for i, m := range f.members {
+		r, ok := m.Queue.(queue.Reserver)
+		if !ok {
+			return nil, false
+		}
+		reservers[i] = r
+	}
+	return reservers, true
+}
+
+// store reserves room for the payload on every member and commits it only
+// if the policy is met. Otherwise all reservations are released, so the
+// sender's retransmit is not stored twice by the members that had room. It
+// returns the committed reservations, nil for members without the message.
+func (f *Fanout) store(payload []byte, wait bool, reservers []queue.Reserver) ([]*queue.Reservation, error) {
+	results := make(chan memberResult, len(f.members))
+	held := make([]*queue.Reservation, len(f.members))
+	var firstErr error
+	for i, r := range reservers {
+		res, err := r.Reserve(payload, wait)
+		if err != nil {
+			slog.Warn("Cannot queue message for client", "client", f.members[i].Name, "error", err)
+			if firstErr == nil {
+				firstErr = fmt.Errorf("client %s: %w", f.members[i].Name, err)
+			}
+			results <- memberResult{index: i, reply: queue.DeliveryData{Status: false, Response: err.Error()}}
+			continue
+		}
+		held[i] = res
+		results <- memberResult{index: i, reply: queue.DeliveryData{Status: true, Response: "stored"}}
+	}
+
+	if reply := f.decide(results); !reply.Status {
+		for _, res := range held {
+			if res != nil {
+				res.Release()
+			}
+		}
+		return nil, firstErr
+	}
+	for i, res := range held {
+		if res == nil {
+			continue
+		}
+		if err := res.Commit(); err != nil {
+			slog.Warn("Cannot queue message for client", "client", f.members[i].Name, "error", err)
+			held[i] = nil
+		}
+	}
+	return held, nil
+}
+
+// decide reads member results until the policy has an answer.
+func (f *Fanout) decide(results <-chan memberResult) queue.DeliveryData {
+	acked, failed := 0, 0
+	for range f.members {
+		r := <-results
+		if r.reply.Status {
+			acked++
+		} else {
+			failed++
+		}
+
+		switch f.policy {
+		case config.FanoutPrimary:
+			if r.index == f.primary {
+				return r.reply
+			}
+		case config.FanoutAny:
+			if r.reply.Status {
+				return r.reply
+			}
+			if failed == len(f.members) {
+				return r.reply
+			}
+		default:
+			if !r.reply.Status {
+				slog.Warn("Client did not acknowledge fanned-out message", "client", f.members[r.index].Name, "response", r.reply.Response)
+				return r.reply
+			}
+			if acked == len(f.members) {
+				return r.reply
+			}
+		}
+	}
+	return queue.DeliveryData{Status: false}
+}
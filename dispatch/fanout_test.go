@@ -0,0 +1,165 @@
+package dispatch
+
+import (
+	"cid_retranslator/config"
+	"cid_retranslator/queue"
+	"errors"
+	"testing"
+	"time"
+)
+
+// fakeQueue answers every dispatched message with a fixed reply.
+type fakeQueue struct {
+	reply queue.DeliveryData
+	err   error
+	delay time.Duration
+	got   int
+}
+
+func (f *fakeQueue) Dispatch(payload []byte, wait bool) (<-chan queue.DeliveryData, error) {
+	f.got++
+	if f.err != nil {
+		return nil, f.err
+	}
+	if !wait {
+		return nil, nil
+	}
+	ch := make(chan queue.DeliveryData, 1)
+	go func() {
+		time.Sleep(f.delay)
+		ch <- f.reply
+		close(ch)
+	}()
+	return ch, nil
+}
+
+var (
+	ack  = queue.DeliveryData{Status: true, Response: "ACK"}
+	nack = queue.DeliveryData{Status: false, Response: "NACK"}
+)
+
+func TestFanout_Policies(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  string
+		primary string
+		a, b    queue.DeliveryData
+		want    bool
+	}{
+		{"all acked", config.FanoutAll, "", ack, ack, true},
+		{"all one nack", config.FanoutAll, "", ack, nack, false},
+		{"any one ack", config.FanoutAny, "", nack, ack, true},
+		{"any none", config.FanoutAny, "", nack, nack, false},
+		{"primary acked", config.FanoutPrimary, "a", ack, nack, true},
+		{"primary nacked", config.FanoutPrimary, "a", nack, ack, false},
+		{"default is all", "", "", ack, nack, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := &fakeQueue{reply: tt.a}
+			b := &fakeQueue{reply: tt.b, delay: 10 * time.Millisecond}
+			f, err := NewFanout([]Member{{"a", a}, {"b", b}}, tt.policy, tt.primary)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			replyCh, err := f.Dispatch([]byte("msg"), true)
+			if err != nil {
+				t.Fatalf("Dispatch() unexpected error: %v", err)
+			}
+			if reply := <-replyCh; reply.Status != tt.want {
+				t.Errorf("Dispatch() status = %v, want %v", reply.Status, tt.want)
+			}
+			if a.got != 1 || b.got != 1 {
+				t.Errorf("message not delivered to every client: a=%d b=%d", a.got, b.got)
+			}
+		})
+	}
+}
+
+func TestFanout_Store(t *testing.T) {
+	full := &fakeQueue{err: queue.ErrFull}
+	ok := &fakeQueue{}
+
+	anyOK, _ := NewFanout([]Member{{"full", full}, {"ok", ok}}, config.FanoutAny, "")
+	if replyCh, err := anyOK.Dispatch([]byte("msg"), false); err != nil || replyCh != nil {
+		t.Errorf("any: Dispatch() = %v, %v; want stored", replyCh, err)
+	}
+
+	allOK, _ := NewFanout([]Member{{"full", full}, {"ok", ok}}, config.FanoutAll, "")
+	if _, err := allOK.Dispatch([]byte("msg"), false); !errors.Is(err, queue.ErrFull) {
+		t.Errorf("all: Dispatch() error = %v, want ErrFull", err)
+	}
+}
+
+func TestNewFanout_UnknownPrimary(t *testing.T) {
+	if _, err := NewFanout([]Member{{"a", &fakeQueue{}}}, config.FanoutPrimary, "b"); err == nil {
+		t.Error("NewFanout() expected an error for an unknown primary")
+	}
+}
+
+func TestFanout_StorePartialFailure(t *testing.T) {
+	full := queue.New(1)
+	if err := full.Enqueue(queue.SharedData{Payload: []byte("earlier")}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		policy  string
+		primary string
+		wait    bool
+		wantErr bool
+	}{
+		{config.FanoutAll, "", false, true},
+		{config.FanoutPrimary, "full", false, true},
+		{config.FanoutPrimary, "ok", false, false},
+		{config.FanoutAny, "", false, false},
+		{config.FanoutAll, "", true, true},
+		{config.FanoutPrimary, "full", true, true},
+		{config.FanoutPrimary, "ok", true, false},
+		{config.FanoutAny, "", true, false},
+	}
+
+	for _, tt := range tests {
+		name := tt.policy + tt.primary
+		if tt.wait {
+			name += "/wait"
+		}
+		t.Run(name, func(t *testing.T) {
+			ok := queue.New(1)
+			f, err := NewFanout([]Member{{"ok", ok}, {"full", full}}, tt.policy, tt.primary)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			replyCh, err := f.Dispatch([]byte("msg"), tt.wait)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Dispatch() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			// A NACKed message must not stay stored anywhere, or the
+			// sender's retransmit is delivered twice.
+			want := 1
+			if tt.wantErr {
+				want = 0
+			}
+			if n := ok.Pending(); n != want {
+				t.Errorf("ok client holds %d messages, want %d", n, want)
+			}
+			if full.Pending() != 1 {
+				t.Errorf("full client holds %d messages, want 1", full.Pending())
+			}
+
+			if tt.wait && !tt.wantErr {
+				data, err := ok.TryPop()
+				if err != nil {
+					t.Fatal(err)
+				}
+				data.Reply(ack)
+				if reply := <-replyCh; !reply.Status {
+					t.Errorf("Dispatch() reply = %+v, want ACK", reply)
+				}
+			}
+		})
+	}
+}
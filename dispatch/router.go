@@ -0,0 +1,179 @@
+package dispatch
+
+import (
+	cidparser "cid_retranslator/cidParser"
+	"cid_retranslator/config"
+	"cid_retranslator/queue"
+	"fmt"
+	"sync/atomic"
+)
+
+// DefaultRoute is the name of the route taken by messages that match no
+// configured route. It delivers to every client.
+const DefaultRoute = "default"
+
+// route is one entry of the rule table with the fan-out to its clients.
+type route struct {
+	name    string
+	match   config.RouteMatch
+	clients []string
+	fanout  *Fanout
+
+	matched atomic.Int64
+	acked   atomic.Int64
+	failed  atomic.Int64
+}
+
+// RouteStat counts the messages taken by a route and how they ended.
+type RouteStat struct {
+	Name    string   `json:"name"`
+	Clients []string `json:"clients"`
+	Matched int64    `json:"matched"`
+	Acked   int64    `json:"acked"`
+	Failed  int64    `json:"failed"`
+}
+
+// Router picks the clients for a message from a rule table. The first
+// matching route wins; the rest go to every client through the default
+// route.
+type Router struct {
+	routes  []*route // default route last
+	classes []config.PriorityClass
+}
+
+// NewRouter creates a Router. classes are the queue priority classes that
+// routes may match by name; defaultPolicy and primary configure the default
+// route.
+func NewRouter(routes []config.RouteConfig, classes []config.PriorityClass, members []Member, defaultPolicy, primary string) (*Router, error) {
+	byName := make(map[string]Member, len(members))
+	for _, m := range members {
+		byName[m.Name] = m
+	}
+
+	r := &Router{classes: classes}
+	for _, rc := range routes {
+		subset := make([]Member, 0, len(rc.Clients))
+		for _, name := range rc.Clients {
+			m, ok := byName[name]
+			if !ok {
+				return nil, fmt.Errorf("route %s: no client named '%s'", rc.Name, name)
+			}
+			subset = append(subset, m)
+		}
+		fanout, err := NewFanout(subset, rc.Fanout.Ack, rc.Fanout.Primary)
+		if err != nil {
+			return nil, fmt.Errorf("route %s: %w", rc.Name, err)
+		}
+
+		r.routes = append(r.routes, &route{name: rc.Name, match: rc.Match, clients: rc.Clients, fanout: fanout})
+	}
+
+	fanout, err := NewFanout(members, defaultPolicy, primary)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, len(members))
+	for i, m := range members {
+		names[i] = m.Name
+	}
+	r.routes = append(r.routes, &route{name: DefaultRoute, clients: names, fanout: fanout})
+	return r, nil
+}
+
+// Dispatch hands the payload to the clients of the first matching route.
+// Payloads that are not Contact ID frames take the default route.
+func (r *Router) Dispatch(payload []byte, wait bool) (<-chan queue.DeliveryData, error) {
+	rt := r.routes[len(r.routes)-1]
+	if msg, err := cidparser.Parse(payload); err == nil {
+		rt = r.find(msg)
+	}
+	return rt.Dispatch(payload, wait)
+}
+
+// For returns a dispatcher that sends every payload through the named route,
+// skipping the rule table. The route's counters are shared with the Router.
+func (r *Router) For(name string) (queue.Dispatcher, error) {
+	for _, rt := range r.routes {
+		if rt.name == name {
+			return rt, nil
+		}
+	}
+	return nil, fmt.Errorf("no route named '%s'", name)
+}
+
+// Dispatch hands the payload to the clients of the route and counts the
+// result.
+func (rt *route) Dispatch(payload []byte, wait bool) (<-chan queue.DeliveryData, error) {
+	rt.matched.Add(1)
+
+	replyCh, err := rt.fanout.Dispatch(payload, wait)
+	if err != nil {
+		rt.failed.Add(1)
+		return nil, err
+	}
+	if replyCh == nil {
+		rt.acked.Add(1)
+		return nil, nil
+	}
+
+	counted := make(chan queue.DeliveryData, 1)
+	go func() {
+		defer close(counted)
+		reply, ok := <-replyCh
+		if !ok || !reply.Status {
+			rt.failed.Add(1)
+		} else {
+			rt.acked.Add(1)
+		}
+		if ok {
+			counted <- reply
+		}
+	}()
+	return counted, nil
+}
+
+func (r *Router) find(msg *cidparser.Message) *route {
+	class := r.classOf(msg.Code)
+	last := len(r.routes) - 1
+	for _, rt := range r.routes[:last] {
+		if rt.matches(msg, class) {
+			return rt
+		}
+	}
+	return r.routes[last]
+}
+
+// classOf returns the priority class of an event code, chosen the same way
+// as the queue lane.
+func (r *Router) classOf(code int) string {
+	for _, class := range r.classes {
+		for _, c := range class.Codes {
+			if c.Contains(code) {
+				return class.Name
+			}
+		}
+	}
+	return config.DefaultLane
+}
+
+func (rt *route) matches(msg *cidparser.Message, class string) bool {
+	return msg.Matches(&rt.match.MessageMatch) &&
+		rt.match.Receivers.Contains(msg.Receiver) &&
+		rt.match.Lines.Contains(msg.Line) &&
+		(rt.match.Class == "" || rt.match.Class == class)
+}
+
+// Stats returns the counters of every route, the default route last.
+func (r *Router) Stats() []RouteStat {
+	stats := make([]RouteStat, len(r.routes))
+	for i, rt := range r.routes {
+		stats[i] = RouteStat{
+			Name:    rt.name,
+			Clients: rt.clients,
+			Matched: rt.matched.Load(),
+			Acked:   rt.acked.Load(),
+			Failed:  rt.failed.Load(),
+		}
+	}
+	return stats
+}
@@ -0,0 +1,124 @@
+package dispatch
+
+import (
+	"cid_retranslator/config"
+	"testing"
+)
+
+func mustRange(t *testing.T, s string) config.Range {
+	t.Helper()
+	r, err := config.ParseRange(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+func TestRouter_Routes(t *testing.T) {
+	classes := []config.PriorityClass{
+		{Name: "alarm", Codes: []config.Range{mustRange(t, "100-199")}},
+		{Name: "trouble", Codes: []config.Range{mustRange(t, "200-399")}},
+	}
+	routes := []config.RouteConfig{
+		{Name: "faults", Match: config.RouteMatch{Class: "trouble"}, Clients: []string{"service"}},
+		{Name: "receiver2", Match: config.RouteMatch{Receivers: mustRange(t, "2"), Lines: mustRange(t, "1")}, Clients: []string{"service"}},
+		{Name: "alarms", Match: config.RouteMatch{MessageMatch: config.MessageMatch{Accounts: mustRange(t, "1000-1999")}}, Clients: []string{"dispatch"}},
+	}
+
+	tests := []struct {
+		name    string
+		payload string
+		route   string
+	}{
+		{"trouble code", "5000 181234E30100001\x14", "faults"},
+		{"receiver and line", "5021 185000E13000001\x14", "receiver2"},
+		{"other line", "5022 185000E13000001\x14", DefaultRoute},
+		{"account range", "5000 181500E13000001\x14", "alarms"},
+		{"no match", "5000 185000E13000001\x14", DefaultRoute},
+		{"not contact id", "garbage", DefaultRoute},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dispatch, service := &fakeQueue{reply: ack}, &fakeQueue{reply: ack}
+			members := []Member{{"dispatch", dispatch}, {"service", service}}
+			r, err := NewRouter(routes, classes, members, config.FanoutAll, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			replyCh, err := r.Dispatch([]byte(tt.payload), true)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if reply := <-replyCh; !reply.Status {
+				t.Errorf("reply = %+v, want ACK", reply)
+			}
+
+			for _, st := range r.Stats() {
+				want := int64(0)
+				if st.Name == tt.route {
+					want = 1
+				}
+				if st.Matched != want || st.Acked != want {
+					t.Errorf("route %s: matched %d, acked %d, want %d", st.Name, st.Matched, st.Acked, want)
+				}
+			}
+		})
+	}
+}
+
+func TestRouter_Failed(t *testing.T) {
+	a := &fakeQueue{reply: nack}
+	r, err := NewRouter(nil, nil, []Member{{"a", a}}, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	replyCh, _ := r.Dispatch([]byte("5000 181234E13000001\x14"), true)
+	<-replyCh
+	if _, ok := <-replyCh; ok {
+		t.Error("reply channel not closed")
+	}
+
+	st := r.Stats()[0]
+	if st.Name != DefaultRoute || st.Failed != 1 || st.Acked != 0 {
+		t.Errorf("stats = %+v, want one failed on the default route", st)
+	}
+}
+
+func TestNewRouter_UnknownClient(t *testing.T) {
+	routes := []config.RouteConfig{{Name: "r", Clients: []string{"missing"}}}
+	if _, err := NewRouter(routes, nil, []Member{{"a", &fakeQueue{}}}, "", ""); err == nil {
+		t.Error("NewRouter() expected error for unknown client")
+	}
+}
+
+func TestRouter_For(t *testing.T) {
+	dispatch, service := &fakeQueue{reply: ack}, &fakeQueue{reply: ack}
+	routes := []config.RouteConfig{
+		{Name: "alarms", Match: config.RouteMatch{MessageMatch: config.MessageMatch{Accounts: mustRange(t, "1000-1999")}}, Clients: []string{"dispatch"}},
+	}
+	r, err := NewRouter(routes, nil, []Member{{"dispatch", dispatch}, {"service", service}}, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d, err := r.For("alarms")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Account 5000 matches no route, but the listener is bound to "alarms".
+	replyCh, err := d.Dispatch([]byte("5000 185000E13000001\x14"), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-replyCh
+
+	if st := r.Stats()[0]; st.Name != "alarms" || st.Matched != 1 || st.Acked != 1 {
+		t.Errorf("stats = %+v, want one acked on alarms", st)
+	}
+	if _, err := r.For("missing"); err == nil {
+		t.Error("For() expected error for unknown route")
+	}
+}
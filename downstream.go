@@ -0,0 +1,106 @@
+package main
+
+import (
+	"cid_retranslator/client"
+	"cid_retranslator/config"
+	"cid_retranslator/queue"
+	"fmt"
+	"path/filepath"
+	"time"
+)
+
+// downstream is one central station: its client and the queue feeding it.
+type downstream struct {
+	name   string
+	queue  *queue.Queue
+	client *client.Client
+}
+
+// openDownstreams creates a queue and client for every configured central
+// station. With several clients each queue journal lives in its own
+// subdirectory of queue.dir.
+func openDownstreams(cfg *config.Config) ([]*downstream, error) {
+	clients := cfg.EffectiveClients()
+	downstreams := make([]*downstream, 0, len(clients))
+	for i := range clients {
+		clientCfg := &clients[i]
+		queueCfg := cfg.Queue
+		if len(cfg.Clients) > 0 && queueCfg.Dir != "" {
+			queueCfg.Dir = filepath.Join(queueCfg.Dir, clientCfg.Name)
+		}
+
+		q, err := queue.Open(&queueCfg)
+		if err != nil {
+			for _, d := range downstreams {
+				d.queue.Close()
+			}
+			return nil, fmt.Errorf("client %s: %w", clientCfg.Name, err)
+		}
+		downstreams = append(downstreams, &downstream{
+			name:   clientCfg.Name,
+			queue:  q,
+			client: client.New(clientCfg, q),
+		})
+	}
+	return downstreams, nil
+}
+
+// ClientStats reports one central station connection.
+type ClientStats struct {
+	Name          string                `json:"name"`
+	Accepted      int                   `json:"accepted"`
+	Rejected      int                   `json:"rejected"`
+	Reconnects    int                   `json:"reconnects"`
+	LastHeartbeat string                `json:"lastHeartbeat"`
+	Pending       int                   `json:"pending"`
+	DeadLetters   int                   `json:"deadLetters"`
+	Lanes         []LaneStats           `json:"lanes"`
+	ActiveTarget  string                `json:"activeTarget"`
+	Targets       []client.TargetStatus `json:"targets"`
+	// CertExpiry is when the client TLS certificate expires, empty if none.
+	CertExpiry string `json:"certExpiry"`
+}
+
+// LaneStats reports the depth of one queue priority lane and how long its
+// messages waited for the client.
+type LaneStats struct {
+	Name    string `json:"name"`
+	Depth   int    `json:"depth"`
+	AvgWait string `json:"avgWait"`
+	MaxWait string `json:"maxWait"`
+}
+
+func (d *downstream) stats() ClientStats {
+	accepted, rejected, reconnects, _ := d.client.GetQueueStats()
+
+	lanes := d.queue.LaneStats()
+	laneStats := make([]LaneStats, len(lanes))
+	for i, l := range lanes {
+		laneStats[i] = LaneStats{
+			Name:    l.Name,
+			Depth:   l.Depth,
+			AvgWait: l.AvgWait.Round(time.Millisecond).String(),
+			MaxWait: l.MaxWait.Round(time.Millisecond).String(),
+		}
+	}
+
+	return ClientStats{
+		Name:          d.name,
+		Accepted:      accepted,
+		Rejected:      rejected,
+		Reconnects:    reconnects,
+		LastHeartbeat: formatTime(d.client.LastHeartbeat()),
+		Pending:       d.queue.Pending(),
+		DeadLetters:   d.queue.DeadLetterCount(),
+		Lanes:         laneStats,
+		ActiveTarget:  d.client.ActiveTarget(),
+		Targets:       d.client.Targets(),
+		CertExpiry:    formatTime(d.client.CertExpiry()),
+	}
+}
+
+// DeadLetterInfo is a dead letter together with the client that gave up on it.
+type DeadLetterInfo struct {
+	Client string `json:"client"`
+	queue.DeadLetter
+}
@@ -1,17 +1,30 @@
 // Cynhyrchwyd y ffeil hon yn awtomatig. PEIDIWCH Â MODIWL
 // This file is automatically generated. DO NOT EDIT
 import {context} from '../models';
+import {config} from '../models';
 import {server} from '../models';
 import {main} from '../models';
 
+export function DisconnectConnection(arg1:number):Promise<void>;
+
 export function DomReady(arg1:context.Context):Promise<void>;
 
+export function GetAccess(arg1:string):Promise<config.AccessConfig>;
+
+export function GetConnections():Promise<Array<server.ConnectionInfo>>;
+
+export function GetDeadLetters():Promise<Array<main.DeadLetterInfo>>;
+
 export function GetDeviceEvents(arg1:number):Promise<Array<server.Event>>;
 
 export function GetDevices():Promise<Array<server.Device>>;
 
 export function GetGlobalEvents():Promise<Array<server.GlobalEvent>>;
 
+export function GetHeartbeats():Promise<Array<server.Heartbeat>>;
+
+export function GetListenerDeviceEvents(arg1:string,arg2:number):Promise<Array<server.Event>>;
+
 export function GetLogs():Promise<Array<string>>;
 
 export function GetStats():Promise<main.Stats>;
@@ -20,8 +33,16 @@ export function Greet(arg1:string):Promise<string>;
 
 export function MinimizeWindow():Promise<void>;
 
+export function PurgeDeadLetters():Promise<number>;
+
 export function Quit():Promise<void>;
 
+export function ReplayAllDeadLetters():Promise<number>;
+
+export function ReplayDeadLetter(arg1:string,arg2:number):Promise<void>;
+
+export function SetAccess(arg1:string,arg2:config.AccessConfig):Promise<void>;
+
 export function ShowWindow():Promise<void>;
 
 export function StartEmitter(arg1:context.Context):Promise<void>;
@@ -2,10 +2,26 @@
 // Cynhyrchwyd y ffeil hon yn awtomatig. PEIDIWCH Â MODIWL
 // This file is automatically generated. DO NOT EDIT
 
+export function DisconnectConnection(arg1) {
+  return window['go']['main']['App']['DisconnectConnection'](arg1);
+}
+
 export function DomReady(arg1) {
   return window['go']['main']['App']['DomReady'](arg1);
 }
 
+export function GetAccess(arg1) {
+  return window['go']['main']['App']['GetAccess'](arg1);
+}
+
+export function GetConnections() {
+  return window['go']['main']['App']['GetConnections']();
+}
+
+export function GetDeadLetters() {
+  return window['go']['main']['App']['GetDeadLetters']();
+}
+
 export function GetDeviceEvents(arg1) {
   return window['go']['main']['App']['GetDeviceEvents'](arg1);
 }
@@ -18,6 +34,14 @@ export function GetGlobalEvents() {
   return window['go']['main']['App']['GetGlobalEvents']();
 }
 
+export function GetHeartbeats() {
+  return window['go']['main']['App']['GetHeartbeats']();
+}
+
+export function GetListenerDeviceEvents(arg1, arg2) {
+  return window['go']['main']['App']['GetListenerDeviceEvents'](arg1, arg2);
+}
+
 export function GetLogs() {
   return window['go']['main']['App']['GetLogs']();
 }
@@ -34,10 +58,26 @@ export function MinimizeWindow() {
   return window['go']['main']['App']['MinimizeWindow']();
 }
 
+export function PurgeDeadLetters() {
+  return window['go']['main']['App']['PurgeDeadLetters']();
+}
+
 export function Quit() {
   return window['go']['main']['App']['Quit']();
 }
 
+export function ReplayAllDeadLetters() {
+  return window['go']['main']['App']['ReplayAllDeadLetters']();
+}
+
+export function ReplayDeadLetter(arg1, arg2) {
+  return window['go']['main']['App']['ReplayDeadLetter'](arg1, arg2);
+}
+
+export function SetAccess(arg1, arg2) {
+  return window['go']['main']['App']['SetAccess'](arg1, arg2);
+}
+
 export function ShowWindow() {
   return window['go']['main']['App']['ShowWindow']();
 }
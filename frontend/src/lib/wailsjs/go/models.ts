@@ -1,10 +1,263 @@
+export namespace client {
+	
+	export class TargetStatus {
+	    name: string;
+	    address: string;
+	    active: boolean;
+	    connected: boolean;
+	    failures: number;
+	    lastError: string;
+	    lastOK: string;
+	    certExpiry: string;
+	
+	    static createFrom(source: any = {}) {
+	        return new TargetStatus(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.name = source["name"];
+	        this.address = source["address"];
+	        this.active = source["active"];
+	        this.connected = source["connected"];
+	        this.failures = source["failures"];
+	        this.lastError = source["lastError"];
+	        this.lastOK = source["lastOK"];
+	        this.certExpiry = source["certExpiry"];
+	    }
+	}
+
+}
+
+export namespace config {
+	
+	export class AccessConfig {
+	    allow: string[];
+	    deny: string[];
+	    maxConnections: number;
+	    rateLimit: number;
+	    rateBurst: number;
+	
+	    static createFrom(source: any = {}) {
+	        return new AccessConfig(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.allow = source["allow"];
+	        this.deny = source["deny"];
+	        this.maxConnections = source["maxConnections"];
+	        this.rateLimit = source["rateLimit"];
+	        this.rateBurst = source["rateBurst"];
+	    }
+	}
+
+}
+
+export namespace dispatch {
+	
+	export class RouteStat {
+	    name: string;
+	    clients: string[];
+	    matched: number;
+	    acked: number;
+	    failed: number;
+	
+	    static createFrom(source: any = {}) {
+	        return new RouteStat(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.name = source["name"];
+	        this.clients = source["clients"];
+	        this.matched = source["matched"];
+	        this.acked = source["acked"];
+	        this.failed = source["failed"];
+	    }
+	}
+
+}
+
 export namespace main {
 	
+	export class LaneStats {
+	    name: string;
+	    depth: number;
+	    avgWait: string;
+	    maxWait: string;
+	
+	    static createFrom(source: any = {}) {
+	        return new LaneStats(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.name = source["name"];
+	        this.depth = source["depth"];
+	        this.avgWait = source["avgWait"];
+	        this.maxWait = source["maxWait"];
+	    }
+	}
+	export class ClientStats {
+	    name: string;
+	    accepted: number;
+	    rejected: number;
+	    reconnects: number;
+	    lastHeartbeat: string;
+	    pending: number;
+	    deadLetters: number;
+	    lanes: LaneStats[];
+	    activeTarget: string;
+	    targets: client.TargetStatus[];
+	    certExpiry: string;
+	
+	    static createFrom(source: any = {}) {
+	        return new ClientStats(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.name = source["name"];
+	        this.accepted = source["accepted"];
+	        this.rejected = source["rejected"];
+	        this.reconnects = source["reconnects"];
+	        this.lastHeartbeat = source["lastHeartbeat"];
+	        this.pending = source["pending"];
+	        this.deadLetters = source["deadLetters"];
+	        this.lanes = this.convertValues(source["lanes"], LaneStats);
+	        this.activeTarget = source["activeTarget"];
+	        this.targets = this.convertValues(source["targets"], client.TargetStatus);
+	        this.certExpiry = source["certExpiry"];
+	    }
+	
+		convertValues(a: any, classs: any, asMap: boolean = false): any {
+		    if (!a) {
+		        return a;
+		    }
+		    if (a.slice && a.map) {
+		        return (a as any[]).map(elem => this.convertValues(elem, classs));
+		    } else if ("object" === typeof a) {
+		        if (asMap) {
+		            for (const key of Object.keys(a)) {
+		                a[key] = new classs(a[key]);
+		            }
+		            return a;
+		        }
+		        return new classs(a);
+		    }
+		    return a;
+		}
+	}
+	export class DeadLetterInfo {
+	    client: string;
+	    id: number;
+	    payload: string;
+	    reason: string;
+	    attempts: number;
+	    // Go type: time
+	    queuedAt: any;
+	    // Go type: time
+	    failedAt: any;
+	
+	    static createFrom(source: any = {}) {
+	        return new DeadLetterInfo(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.client = source["client"];
+	        this.id = source["id"];
+	        this.payload = source["payload"];
+	        this.reason = source["reason"];
+	        this.attempts = source["attempts"];
+	        this.queuedAt = this.convertValues(source["queuedAt"], null);
+	        this.failedAt = this.convertValues(source["failedAt"], null);
+	    }
+	
+		convertValues(a: any, classs: any, asMap: boolean = false): any {
+		    if (!a) {
+		        return a;
+		    }
+		    if (a.slice && a.map) {
+		        return (a as any[]).map(elem => this.convertValues(elem, classs));
+		    } else if ("object" === typeof a) {
+		        if (asMap) {
+		            for (const key of Object.keys(a)) {
+		                a[key] = new classs(a[key]);
+		            }
+		            return a;
+		        }
+		        return new classs(a);
+		    }
+		    return a;
+		}
+	}
+	
+	export class ListenerStats {
+	    name: string;
+	    address: string;
+	    protocol: string;
+	    route: string;
+	    connections: number;
+	    devices: number;
+	    filters: server.FilterStat[];
+	    duplicates: number;
+	    certExpiry: string;
+	    access: server.AccessStats;
+	
+	    static createFrom(source: any = {}) {
+	        return new ListenerStats(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.name = source["name"];
+	        this.address = source["address"];
+	        this.protocol = source["protocol"];
+	        this.route = source["route"];
+	        this.connections = source["connections"];
+	        this.devices = source["devices"];
+	        this.filters = this.convertValues(source["filters"], server.FilterStat);
+	        this.duplicates = source["duplicates"];
+	        this.certExpiry = source["certExpiry"];
+	        this.access = this.convertValues(source["access"], server.AccessStats);
+	    }
+	
+		convertValues(a: any, classs: any, asMap: boolean = false): any {
+		    if (!a) {
+		        return a;
+		    }
+		    if (a.slice && a.map) {
+		        return (a as any[]).map(elem => this.convertValues(elem, classs));
+		    } else if ("object" === typeof a) {
+		        if (asMap) {
+		            for (const key of Object.keys(a)) {
+		                a[key] = new classs(a[key]);
+		            }
+		            return a;
+		        }
+		        return new classs(a);
+		    }
+		    return a;
+		}
+	}
 	export class Stats {
 	    accepted: number;
 	    rejected: number;
 	    uptime: string;
 	    reconnects: number;
+	    accountMapHits: number;
+	    accountMapMisses: number;
+	    filters: server.FilterStat[];
+	    pending: number;
+	    duplicates: number;
+	    deadLetters: number;
+	    clients: ClientStats[];
+	    routes: dispatch.RouteStat[];
+	    certExpiry: string;
+	    access: server.AccessStats;
+	    listeners: ListenerStats[];
 	
 	    static createFrom(source: any = {}) {
 	        return new Stats(source);
@@ -16,13 +269,84 @@ export namespace main {
 	        this.rejected = source["rejected"];
 	        this.uptime = source["uptime"];
 	        this.reconnects = source["reconnects"];
+	        this.accountMapHits = source["accountMapHits"];
+	        this.accountMapMisses = source["accountMapMisses"];
+	        this.filters = this.convertValues(source["filters"], server.FilterStat);
+	        this.pending = source["pending"];
+	        this.duplicates = source["duplicates"];
+	        this.deadLetters = source["deadLetters"];
+	        this.clients = this.convertValues(source["clients"], ClientStats);
+	        this.routes = this.convertValues(source["routes"], dispatch.RouteStat);
+	        this.certExpiry = source["certExpiry"];
+	        this.access = this.convertValues(source["access"], server.AccessStats);
+	        this.listeners = this.convertValues(source["listeners"], ListenerStats);
 	    }
+	
+		convertValues(a: any, classs: any, asMap: boolean = false): any {
+		    if (!a) {
+		        return a;
+		    }
+		    if (a.slice && a.map) {
+		        return (a as any[]).map(elem => this.convertValues(elem, classs));
+		    } else if ("object" === typeof a) {
+		        if (asMap) {
+		            for (const key of Object.keys(a)) {
+		                a[key] = new classs(a[key]);
+		            }
+		            return a;
+		        }
+		        return new classs(a);
+		    }
+		    return a;
+		}
 	}
 
 }
 
 export namespace server {
 	
+	export class AccessStats {
+	    denied: number;
+	    overLimit: number;
+	    rateLimited: number;
+	
+	    static createFrom(source: any = {}) {
+	        return new AccessStats(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.denied = source["denied"];
+	        this.overLimit = source["overLimit"];
+	        this.rateLimited = source["rateLimited"];
+	    }
+	}
+	export class ConnectionInfo {
+	    id: number;
+	    listener: string;
+	    remoteAddr: string;
+	    connectedAt: string;
+	    bytesIn: number;
+	    messagesIn: number;
+	    lastMessage: string;
+	    lastHeartbeat: string;
+	
+	    static createFrom(source: any = {}) {
+	        return new ConnectionInfo(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.id = source["id"];
+	        this.listener = source["listener"];
+	        this.remoteAddr = source["remoteAddr"];
+	        this.connectedAt = source["connectedAt"];
+	        this.bytesIn = source["bytesIn"];
+	        this.messagesIn = source["messagesIn"];
+	        this.lastMessage = source["lastMessage"];
+	        this.lastHeartbeat = source["lastHeartbeat"];
+	    }
+	}
 	export class Event {
 	    time: string;
 	    data: string;
@@ -39,6 +363,7 @@ export namespace server {
 	}
 	export class Device {
 	    id: number;
+	    listener: string;
 	    lastEventTime: string;
 	    lastEvent: string;
 	    events: Event[];
@@ -50,6 +375,7 @@ export namespace server {
 	    constructor(source: any = {}) {
 	        if ('string' === typeof source) source = JSON.parse(source);
 	        this.id = source["id"];
+	        this.listener = source["listener"];
 	        this.lastEventTime = source["lastEventTime"];
 	        this.lastEvent = source["lastEvent"];
 	        this.events = this.convertValues(source["events"], Event);
@@ -74,9 +400,26 @@ export namespace server {
 		}
 	}
 	
+	export class FilterStat {
+	    name: string;
+	    action: string;
+	    hits: number;
+	
+	    static createFrom(source: any = {}) {
+	        return new FilterStat(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.name = source["name"];
+	        this.action = source["action"];
+	        this.hits = source["hits"];
+	    }
+	}
 	export class GlobalEvent {
 	    time: string;
 	    deviceID: number;
+	    listener: string;
 	    data: string;
 	
 	    static createFrom(source: any = {}) {
@@ -87,9 +430,30 @@ export namespace server {
 	        if ('string' === typeof source) source = JSON.parse(source);
 	        this.time = source["time"];
 	        this.deviceID = source["deviceID"];
+	        this.listener = source["listener"];
 	        this.data = source["data"];
 	    }
 	}
+	export class Heartbeat {
+	    connectionID: number;
+	    listener: string;
+	    remoteAddr: string;
+	    connectedAt: string;
+	    lastHeartbeat: string;
+	
+	    static createFrom(source: any = {}) {
+	        return new Heartbeat(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.connectionID = source["connectionID"];
+	        this.listener = source["listener"];
+	        this.remoteAddr = source["remoteAddr"];
+	        this.connectedAt = source["connectedAt"];
+	        this.lastHeartbeat = source["lastHeartbeat"];
+	    }
+	}
 
 }
 
@@ -0,0 +1,198 @@
+package queue
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const deadLetterFile = "deadletter.json"
+
+// ErrNotFound is returned when a dead letter does not exist.
+var ErrNotFound = errors.New("dead letter not found")
+
+// DeadLetter is a message the client gave up on.
+type DeadLetter struct {
+	ID       uint64    `json:"id"`
+	Payload  string    `json:"payload"`
+	Reason   string    `json:"reason"`
+	Attempts int       `json:"attempts"`
+	QueuedAt time.Time `json:"queuedAt"`
+	FailedAt time.Time `json:"failedAt"`
+}
+
+// deadLetters keeps failed messages for inspection and replay. With a path it
+// is saved as JSON after every change; the oldest entries are dropped beyond
+// limit.
+type deadLetters struct {
+	mu      sync.Mutex
+	path    string
+	limit   int
+	nextID  uint64
+	entries []DeadLetter
+}
+
+func openDeadLetters(dir string, limit int) (*deadLetters, error) {
+	d := &deadLetters{limit: limit, nextID: 1}
+	if dir == "" {
+		return d, nil
+	}
+	d.path = filepath.Join(dir, deadLetterFile)
+
+	data, err := os.ReadFile(d.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return d, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading dead letters: %w", err)
+	}
+	if err := json.Unmarshal(data, &d.entries); err != nil {
+		return nil, fmt.Errorf("error parsing dead letters %s: %w", d.path, err)
+	}
+	for _, e := range d.entries {
+		if e.ID >= d.nextID {
+			d.nextID = e.ID + 1
+		}
+	}
+	return d, nil
+}
+
+func (d *deadLetters) add(entry DeadLetter) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	entry.ID = d.nextID
+	d.nextID++
+	d.entries = append(d.entries, entry)
+	if d.limit > 0 && len(d.entries) > d.limit {
+		slog.Warn("Dead-letter store full, dropping oldest entries", "dropped", len(d.entries)-d.limit)
+		d.entries = d.entries[len(d.entries)-d.limit:]
+	}
+	d.save()
+}
+
+func (d *deadLetters) list() []DeadLetter {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]DeadLetter{}, d.entries...)
+}
+
+func (d *deadLetters) len() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return len(d.entries)
+}
+
+// take removes and returns one entry.
+func (d *deadLetters) take(id uint64) (DeadLetter, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for i, e := range d.entries {
+		if e.ID == id {
+			d.entries = append(d.entries[:i], d.entries[i+1:]...)
+			d.save()
+			return e, true
+		}
+	}
+	return DeadLetter{}, false
+}
+
+// restore puts back an entry that could not be replayed.
+func (d *deadLetters) restore(entry DeadLetter) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.entries = append(d.entries, entry)
+	d.save()
+}
+
+func (d *deadLetters) purge() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	n := len(d.entries)
+	d.entries = nil
+	d.save()
+	return n
+}
+
+// save must be called with d.mu held.
+func (d *deadLetters) save() {
+	if d.path == "" {
+		return
+	}
+	data, err := json.MarshalIndent(d.entries, "", "  ")
+	if err != nil {
+		slog.Error("Cannot encode dead letters", "error", err)
+		return
+	}
+	tmp := d.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		slog.Error("Cannot save dead letters", "path", d.path, "error", err)
+		return
+	}
+	if err := os.Rename(tmp, d.path); err != nil {
+		slog.Error("Cannot save dead letters", "path", d.path, "error", err)
+	}
+}
+
+// DeadLetter moves a message the client gave up on into the dead-letter
+// store and removes it from the journal.
+func (q *Queue) DeadLetter(data SharedData, reason string, attempts int) {
+	q.dead.add(DeadLetter{
+		Payload:  string(data.Payload),
+		Reason:   reason,
+		Attempts: attempts,
+		QueuedAt: data.enqueued,
+		FailedAt: time.Now(),
+	})
+	q.Complete(data)
+}
+
+// DeadLetters returns the failed messages, oldest first.
+func (q *Queue) DeadLetters() []DeadLetter {
+	return q.dead.list()
+}
+
+// DeadLetterCount returns the number of failed messages.
+func (q *Queue) DeadLetterCount() int {
+	return q.dead.len()
+}
+
+// Replay queues a dead letter for delivery again. Nobody waits for the
+// reply; a new failure puts it back in the store.
+func (q *Queue) Replay(id uint64) error {
+	entry, ok := q.dead.take(id)
+	if !ok {
+		return ErrNotFound
+	}
+	if err := q.Enqueue(SharedData{Payload: []byte(entry.Payload)}); err != nil {
+		q.dead.restore(entry)
+		return err
+	}
+	return nil
+}
+
+// ReplayAll queues every dead letter again and returns how many were queued.
+func (q *Queue) ReplayAll() (int, error) {
+	replayed := 0
+	for _, entry := range q.dead.list() {
+		err := q.Replay(entry.ID)
+		switch {
+		case err == nil:
+			replayed++
+		case !errors.Is(err, ErrNotFound):
+			return replayed, err
+		}
+	}
+	return replayed, nil
+}
+
+// PurgeDeadLetters deletes all dead letters and returns how many there were.
+func (q *Queue) PurgeDeadLetters() int {
+	return q.dead.purge()
+}
@@ -0,0 +1,97 @@
+package queue
+
+// Dispatcher hands messages from a listener to one or more downstream queues.
+type Dispatcher interface {
+	// Dispatch queues the payload. With wait set, the returned channel
+	// delivers the downstream result once; otherwise it is nil and the
+	// message only has to be stored.
+	Dispatch(payload []byte, wait bool) (<-chan DeliveryData, error)
+}
+
+// Reserver is a Dispatcher that can set room aside for a message before
+// storing it, so that a message for several queues is stored by all of them
+// or by none.
+type Reserver interface {
+	Dispatcher
+	Reserve(payload []byte, wait bool) (*Reservation, error)
+}
+
+// Reservation is a message stored in the journal that holds a slot in its
+// lane until it is committed to the lane or released. It belongs to the
+// goroutine that reserved it.
+type Reservation struct {
+	q    *Queue
+	lane *lane
+	data SharedData
+	done bool
+}
+
+// Reserve journals the payload and takes a slot for it without making it
+// visible to the consumer. With wait set, ReplyCh delivers the result once
+// the message is committed. It fails with ErrFull or ErrClosed like Enqueue.
+func (q *Queue) Reserve(payload []byte, wait bool) (*Reservation, error) {
+	q.closeMu.RLock()
+	defer q.closeMu.RUnlock()
+	if q.closed {
+		return nil, ErrClosed
+	}
+
+	data := SharedData{Payload: payload}
+	if wait {
+		data.ReplyCh = make(chan DeliveryData, 1)
+	}
+	l, data, err := q.reserve(data)
+	if err != nil {
+		return nil, err
+	}
+	return &Reservation{q: q, lane: l, data: data}, nil
+}
+
+// ReplyCh returns the channel for the result of a committed message, nil
+// unless it was reserved with wait.
+func (r *Reservation) ReplyCh() <-chan DeliveryData {
+	return r.data.ReplyCh
+}
+
+// Commit puts the reserved message in its lane. It fails with ErrClosed if
+// the queue was closed meanwhile; a journaled message is then delivered
+// after the next start.
+func (r *Reservation) Commit() error {
+	r.q.closeMu.RLock()
+	defer r.q.closeMu.RUnlock()
+	if r.done {
+		return nil
+	}
+	r.done = true
+	if r.q.closed {
+		return ErrClosed
+	}
+	r.q.put(r.lane, r.data)
+	return nil
+}
+
+// Release drops the reserved message and frees its slot.
+func (r *Reservation) Release() {
+	r.q.closeMu.RLock()
+	defer r.q.closeMu.RUnlock()
+	if r.done {
+		return
+	}
+	r.done = true
+	r.lane.give()
+	if !r.q.closed {
+		r.q.Complete(r.data)
+	}
+}
+
+// Dispatch makes a single Queue a Dispatcher.
+func (q *Queue) Dispatch(payload []byte, wait bool) (<-chan DeliveryData, error) {
+	data := SharedData{Payload: payload}
+	if wait {
+		data.ReplyCh = make(chan DeliveryData, 1)
+	}
+	if err := q.Enqueue(data); err != nil {
+		return nil, err
+	}
+	return data.ReplyCh, nil
+}
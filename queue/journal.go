@@ -0,0 +1,361 @@
+package queue
+
+import (
+	"bufio"
+	"cid_retranslator/config"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	journalFile = "queue.journal"
+
+	recordPut byte = 1
+	recordAck byte = 2
+
+	// type + id + payload length
+	recordHeader = 1 + 8 + 4
+	maxPayload   = 64 * 1024
+)
+
+// journal is an append-only log of queued and acknowledged messages. Every
+// message is written as a put record when it enters the queue and as an ack
+// record once it no longer needs delivery. Messages with a put but no ack are
+// re-delivered after a restart.
+type journal struct {
+	mu           sync.Mutex
+	path         string
+	file         *os.File
+	writer       *bufio.Writer
+	fsync        string
+	nextID       uint64
+	pending      map[uint64][]byte
+	acked        int
+	compactAfter int
+	dirty        bool
+	stop         chan struct{}
+	done         chan struct{}
+}
+
+// journalEntry is a message recovered from the journal.
+type journalEntry struct {
+	id      uint64
+	payload []byte
+}
+
+// openJournal opens or creates the journal in dir and returns the messages
+// that were never acknowledged, oldest first.
+func openJournal(dir, fsync string, interval time.Duration, compactAfter int) (*journal, []journalEntry, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, nil, fmt.Errorf("error creating queue directory: %w", err)
+	}
+	path := filepath.Join(dir, journalFile)
+
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error opening queue journal: %w", err)
+	}
+
+	j := &journal{
+		path:         path,
+		file:         file,
+		fsync:        fsync,
+		pending:      make(map[uint64][]byte),
+		compactAfter: compactAfter,
+	}
+	if err := j.replay(); err != nil {
+		file.Close()
+		return nil, nil, err
+	}
+	j.writer = bufio.NewWriter(file)
+
+	if fsync == config.FsyncInterval && interval > 0 {
+		j.stop = make(chan struct{})
+		j.done = make(chan struct{})
+		go j.syncLoop(interval)
+	}
+
+	entries := make([]journalEntry, 0, len(j.pending))
+	for id, payload := range j.pending {
+		entries = append(entries, journalEntry{id: id, payload: payload})
+	}
+	sort.Slice(entries, func(a, b int) bool { return entries[a].id < entries[b].id })
+	return j, entries, nil
+}
+
+// replay reads all records and truncates a torn record at the end of the file.
+func (j *journal) replay() error {
+	reader := bufio.NewReader(j.file)
+	var offset int64
+	for {
+		kind, id, payload, n, err := readRecord(reader)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			slog.Warn("Truncating damaged queue journal tail", "path", j.path, "offset", offset, "error", err)
+			if err := j.file.Truncate(offset); err != nil {
+				return fmt.Errorf("error truncating queue journal: %w", err)
+			}
+			break
+		}
+		offset += n
+
+		switch kind {
+		case recordPut:
+			j.pending[id] = payload
+		case recordAck:
+			delete(j.pending, id)
+		}
+		if id >= j.nextID {
+			j.nextID = id + 1
+		}
+	}
+
+	if _, err := j.file.Seek(offset, io.SeekStart); err != nil {
+		return fmt.Errorf("error seeking queue journal: %w", err)
+	}
+	if j.nextID == 0 {
+		j.nextID = 1
+	}
+	return nil
+}
+
+func readRecord(r io.Reader) (kind byte, id uint64, payload []byte, n int64, err error) {
+	header := make([]byte, recordHeader)
+	if _, err = io.ReadFull(r, header); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			err = errors.New("truncated record header")
+		}
+		return
+	}
+	kind = header[0]
+	id = binary.BigEndian.Uint64(header[1:9])
+	size := binary.BigEndian.Uint32(header[9:13])
+	if kind != recordPut && kind != recordAck || size > maxPayload {
+		err = fmt.Errorf("invalid record header")
+		return
+	}
+
+	body := make([]byte, int(size)+4)
+	if _, err = io.ReadFull(r, body); err != nil {
+		err = errors.New("truncated record body")
+		return
+	}
+	payload = body[:size]
+	sum := binary.BigEndian.Uint32(body[size:])
+	if crc32.ChecksumIEEE(append(header, payload...)) != sum {
+		err = errors.New("record checksum mismatch")
+		return
+	}
+	n = int64(recordHeader) + int64(len(body))
+	return
+}
+
+func encodeRecord(kind byte, id uint64, payload []byte) []byte {
+	record := make([]byte, recordHeader, recordHeader+len(payload)+4)
+	record[0] = kind
+	binary.BigEndian.PutUint64(record[1:9], id)
+	binary.BigEndian.PutUint32(record[9:13], uint32(len(payload)))
+	record = append(record, payload...)
+	return binary.BigEndian.AppendUint32(record, crc32.ChecksumIEEE(record))
+}
+
+// append stores a new message and returns its id.
+func (j *journal) append(payload []byte) (uint64, error) {
+	if len(payload) > maxPayload {
+		return 0, fmt.Errorf("payload of %d bytes exceeds journal limit", len(payload))
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	id := j.nextID
+	if err := j.write(encodeRecord(recordPut, id, payload)); err != nil {
+		return 0, err
+	}
+	j.nextID++
+	j.pending[id] = append([]byte(nil), payload...)
+	return id, nil
+}
+
+// ack marks a message as no longer needing delivery.
+func (j *journal) ack(id uint64) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if _, ok := j.pending[id]; !ok {
+		return nil
+	}
+	if err := j.write(encodeRecord(recordAck, id, nil)); err != nil {
+		return err
+	}
+	delete(j.pending, id)
+
+	j.acked++
+	if j.compactAfter > 0 && j.acked >= j.compactAfter {
+		if err := j.compact(); err != nil {
+			slog.Error("Queue journal compaction failed", "path", j.path, "error", err)
+		}
+	}
+	return nil
+}
+
+// len returns the number of unacknowledged messages.
+func (j *journal) len() int {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return len(j.pending)
+}
+
+// write must be called with j.mu held.
+func (j *journal) write(record []byte) error {
+	if j.file == nil {
+		return errors.New("queue journal is closed")
+	}
+	if _, err := j.writer.Write(record); err != nil {
+		return fmt.Errorf("error writing queue journal: %w", err)
+	}
+	if err := j.writer.Flush(); err != nil {
+		return fmt.Errorf("error writing queue journal: %w", err)
+	}
+	if j.fsync == config.FsyncAlways {
+		if err := j.file.Sync(); err != nil {
+			return fmt.Errorf("error syncing queue journal: %w", err)
+		}
+	} else {
+		j.dirty = true
+	}
+	return nil
+}
+
+// compact rewrites the journal with only the pending messages. It must be
+// called with j.mu held. The counter is reset even when compaction fails, so
+// a failing rewrite is retried after the next compactAfter acks rather than
+// on every ack.
+func (j *journal) compact() error {
+	j.acked = 0
+
+	ids := make([]uint64, 0, len(j.pending))
+	for id := range j.pending {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(a, b int) bool { return ids[a] < ids[b] })
+
+	tmpPath := j.path + ".tmp"
+	if err := writeSnapshot(tmpPath, ids, j.pending); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	// Windows cannot replace a file that is still open.
+	if err := j.file.Close(); err != nil {
+		slog.Warn("Error closing queue journal before compaction", "error", err)
+	}
+	renameErr := os.Rename(tmpPath, j.path)
+	file, err := os.OpenFile(j.path, os.O_RDWR|os.O_APPEND, 0644)
+	if err != nil {
+		j.file = nil
+		return fmt.Errorf("error reopening queue journal: %w", err)
+	}
+	j.file = file
+	j.writer = bufio.NewWriter(file)
+	if renameErr != nil {
+		os.Remove(tmpPath)
+		return renameErr
+	}
+
+	j.dirty = false
+	slog.Debug("Queue journal compacted", "path", j.path, "pending", len(ids))
+	return syncDir(filepath.Dir(j.path))
+}
+
+// writeSnapshot writes put records for ids to path and syncs it.
+func writeSnapshot(path string, ids []uint64, pending map[uint64][]byte) error {
+	tmp, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(tmp)
+	for _, id := range ids {
+		if _, err := w.Write(encodeRecord(recordPut, id, pending[id])); err != nil {
+			tmp.Close()
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	return tmp.Close()
+}
+
+// syncDir makes a rename in dir durable. Windows cannot sync directories
+// and persists renames itself.
+func syncDir(dir string) error {
+	if runtime.GOOS == "windows" {
+		return nil
+	}
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+func (j *journal) syncLoop(interval time.Duration) {
+	defer close(j.done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-j.stop:
+			return
+		case <-ticker.C:
+			j.mu.Lock()
+			if j.dirty && j.file != nil {
+				if err := j.file.Sync(); err != nil {
+					slog.Error("Error syncing queue journal", "error", err)
+				}
+				j.dirty = false
+			}
+			j.mu.Unlock()
+		}
+	}
+}
+
+// close syncs and closes the journal file.
+func (j *journal) close() error {
+	if j.stop != nil {
+		close(j.stop)
+		<-j.done
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.file == nil {
+		return nil
+	}
+	err := j.file.Sync()
+	if cerr := j.file.Close(); err == nil {
+		err = cerr
+	}
+	j.file = nil
+	return err
+}
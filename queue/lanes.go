@@ -0,0 +1,192 @@
+package queue
+
+import (
+	"cid_retranslator/cidParser"
+	"cid_retranslator/config"
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrEmpty is returned by TryPop when no message is waiting.
+var ErrEmpty = errors.New("queue is empty")
+
+// ErrClosed is returned by Pop and Enqueue once the queue has been closed.
+var ErrClosed = errors.New("queue closed")
+
+// lane is one priority class of the queue. The lowest lane uses DataChannel.
+type lane struct {
+	name  string
+	codes []config.Range
+	ch    chan SharedData
+
+	// reserved counts the slots taken by messages not yet in ch.
+	slots    sync.Mutex
+	reserved int
+
+	mu        sync.Mutex
+	popped    int64
+	totalWait time.Duration
+	maxWait   time.Duration
+}
+
+// LaneStat reports the depth of a lane and how long its messages waited.
+type LaneStat struct {
+	Name    string
+	Depth   int
+	Popped  int64
+	AvgWait time.Duration
+	MaxWait time.Duration
+}
+
+// newLanes creates the lanes without their channels, highest priority first.
+func newLanes(classes []config.PriorityClass) []*lane {
+	lanes := make([]*lane, 0, len(classes)+1)
+	for _, class := range classes {
+		lanes = append(lanes, &lane{name: class.Name, codes: class.Codes})
+	}
+	return append(lanes, &lane{name: config.DefaultLane})
+}
+
+// laneFor returns the index of the lane for a Contact ID payload.
+// Unparsable payloads go to the default lane.
+func (q *Queue) laneFor(payload []byte) int {
+	def := len(q.lanes) - 1
+	if def == 0 {
+		return def
+	}
+	msg, err := cidparser.Parse(payload)
+	if err != nil {
+		return def
+	}
+	for i, l := range q.lanes[:def] {
+		for _, r := range l.codes {
+			if r.Contains(msg.Code) {
+				return i
+			}
+		}
+	}
+	return def
+}
+
+// signal wakes a consumer blocked in Pop.
+func (q *Queue) signal() {
+	select {
+	case q.notify <- struct{}{}:
+	default:
+	}
+}
+
+// Pop returns the next message, always draining higher-priority lanes first.
+// It blocks until a message is available, ctx is done or the queue is closed.
+func (q *Queue) Pop(ctx context.Context) (SharedData, error) {
+	for {
+		data, err := q.TryPop()
+		if err != ErrEmpty {
+			return data, err
+		}
+
+		select {
+		case <-q.notify:
+		case <-ctx.Done():
+			return SharedData{}, ctx.Err()
+		}
+	}
+}
+
+// TryPop returns the next message like Pop, or ErrEmpty instead of waiting.
+func (q *Queue) TryPop() (SharedData, error) {
+	for _, l := range q.lanes {
+		select {
+		case data, ok := <-l.ch:
+			if !ok {
+				return SharedData{}, ErrClosed
+			}
+			l.record(time.Since(data.enqueued))
+			return data, nil
+		default:
+		}
+	}
+	return SharedData{}, ErrEmpty
+}
+
+// Ready returns a channel that receives after a message is enqueued, for a
+// consumer that waits on other events as well. It shares the signal with
+// Pop, so the consumer calls TryPop until ErrEmpty before waiting on it.
+func (q *Queue) Ready() <-chan struct{} {
+	return q.notify
+}
+
+// Sweep takes every queued message out of its lane and puts back, in the
+// same order, those that keep returns true for; keep may update them. The
+// others are returned. Enqueue waits meanwhile, so no message overtakes
+// another. Only the consumer may call it, never concurrently with Pop.
+func (q *Queue) Sweep(keep func(*SharedData) bool) []SharedData {
+	q.closeMu.Lock()
+	defer q.closeMu.Unlock()
+	if q.closed {
+		return nil
+	}
+
+	var dropped []SharedData
+	for _, l := range q.lanes {
+		for n := len(l.ch); n > 0; n-- {
+			data := <-l.ch
+			if keep(&data) {
+				l.ch <- data
+			} else {
+				dropped = append(dropped, data)
+			}
+		}
+	}
+	return dropped
+}
+
+// take reserves a slot for a message, or reports false when the lane is
+// full.
+func (l *lane) take() bool {
+	l.slots.Lock()
+	defer l.slots.Unlock()
+	if len(l.ch)+l.reserved >= cap(l.ch) {
+		return false
+	}
+	l.reserved++
+	return true
+}
+
+// give returns a slot reserved by take.
+func (l *lane) give() {
+	l.slots.Lock()
+	defer l.slots.Unlock()
+	l.reserved--
+}
+
+func (l *lane) record(wait time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.popped++
+	l.totalWait += wait
+	if wait > l.maxWait {
+		l.maxWait = wait
+	}
+}
+
+// LaneStats returns the metrics of every lane, highest priority first.
+func (q *Queue) LaneStats() []LaneStat {
+	stats := make([]LaneStat, len(q.lanes))
+	for i, l := range q.lanes {
+		l.mu.Lock()
+		stats[i] = LaneStat{
+			Name:    l.name,
+			Depth:   len(l.ch),
+			Popped:  l.popped,
+			MaxWait: l.maxWait,
+		}
+		if l.popped > 0 {
+			stats[i].AvgWait = l.totalWait / time.Duration(l.popped)
+		}
+		l.mu.Unlock()
+	}
+	return stats
+}
@@ -1,44 +1,224 @@
 package queue
 
 import (
+	"cid_retranslator/config"
+	"errors"
+	"fmt"
+	"log/slog"
 	"sync"
 	"time"
 )
 
+// ErrFull is returned by Enqueue when the buffer has no room.
+var ErrFull = errors.New("queue buffer full")
+
 // Queue encapsulates the channels used for communication between the server and client.
 type Queue struct {
 	DataChannel chan SharedData
-	closeOnce   sync.Once
+	// closeMu guards closed; Close holds it exclusively while closing the
+	// lanes so no Enqueue can send on a closed channel.
+	closeMu     sync.RWMutex
+	closed      bool
 	accepted int
 	rejected int
 	reconnects int
 	mu sync.RWMutex
 	StartTime   time.Time
+	journal     *journal
+	// lanes are ordered by priority; the last one is the default lane and
+	// uses DataChannel.
+	lanes  []*lane
+	notify chan struct{}
+	dead   *deadLetters
 }
 
 // SharedData is the data structure sent from the server to the client.
 type SharedData struct {
+	// ID identifies the message in the journal, zero for in-memory queues.
+	ID      uint64
 	Payload []byte
+	// ReplyCh is nil for messages recovered from the journal, as nobody is
+	// waiting for them any more.
 	ReplyCh chan DeliveryData
+	// Attempts counts the delivery attempts made while the message was
+	// still queued, e.g. dials that failed. See Sweep.
+	Attempts int
+
+	enqueued time.Time
+}
+
+// Reply delivers the result to the waiting sender, if any, and closes ReplyCh.
+func (d SharedData) Reply(reply DeliveryData) {
+	if d.ReplyCh == nil {
+		return
+	}
+	d.ReplyCh <- reply
+	close(d.ReplyCh)
 }
 
 // DeliveryData is the data structure for delivery status replies.
 type DeliveryData struct {
 	Status bool
+	// Response is the receiver's reply as reported by the client, e.g. "ACK", "NAK" or "DUH".
+	Response string
 }
 
 // New creates and initializes a new Queue.
 func New(bufferSize int) *Queue {
-	return &Queue{
-		DataChannel: make(chan SharedData, bufferSize),
+	return newQueue(bufferSize, nil, nil)
+}
+
+// newQueue builds the lanes and puts the recovered messages back, oldest
+// first. Every lane holds bufferSize messages plus its recovered backlog.
+func newQueue(bufferSize int, classes []config.PriorityClass, recovered []journalEntry) *Queue {
+	q := &Queue{
+		lanes:  newLanes(classes),
+		notify: make(chan struct{}, 1),
+		dead:   &deadLetters{nextID: 1},
+	}
+
+	targets := make([]int, len(recovered))
+	backlog := make([]int, len(q.lanes))
+	for i, entry := range recovered {
+		targets[i] = q.laneFor(entry.payload)
+		backlog[targets[i]]++
+	}
+	for i, l := range q.lanes {
+		l.ch = make(chan SharedData, bufferSize+backlog[i])
+	}
+	q.DataChannel = q.lanes[len(q.lanes)-1].ch
+
+	now := time.Now()
+	for i, entry := range recovered {
+		q.lanes[targets[i]].ch <- SharedData{ID: entry.id, Payload: entry.payload, enqueued: now}
+	}
+	if len(recovered) > 0 {
+		q.signal()
+	}
+	return q
+}
+
+// Open creates a Queue with the priority lanes of cfg, backed by the journal
+// and dead-letter store in cfg.Dir. Messages that were queued but never
+// completed before the last exit are put back in their lanes. With an empty Dir the queue is kept in
+// memory only.
+func Open(cfg *config.QueueConfig) (*Queue, error) {
+	if cfg.Dir == "" {
+		q := newQueue(cfg.BufferSize, cfg.Priorities, nil)
+		q.dead.limit = cfg.MaxDeadLetters
+		return q, nil
+	}
+
+	fsync := cfg.Fsync
+	if fsync == "" {
+		fsync = config.FsyncAlways
+	}
+	j, recovered, err := openJournal(cfg.Dir, fsync, cfg.FsyncInterval, cfg.CompactAfter)
+	if err != nil {
+		return nil, err
+	}
+
+	dead, err := openDeadLetters(cfg.Dir, cfg.MaxDeadLetters)
+	if err != nil {
+		j.close()
+		return nil, err
+	}
+
+	q := newQueue(cfg.BufferSize, cfg.Priorities, recovered)
+	q.journal = j
+	q.dead = dead
+	if len(recovered) > 0 {
+		slog.Info("Recovered undelivered messages from queue journal", "count", len(recovered), "dir", cfg.Dir)
 	}
+	return q, nil
 }
 
-// Close closes the channels in the queue.
+// Enqueue stores the message in the journal, if any, and puts it in the lane
+// for its event code. It returns ErrFull without blocking when that lane has
+// no room and ErrClosed once the queue has been closed.
+func (q *Queue) Enqueue(data SharedData) error {
+	q.closeMu.RLock()
+	defer q.closeMu.RUnlock()
+	if q.closed {
+		return ErrClosed
+	}
+
+	l, data, err := q.reserve(data)
+	if err != nil {
+		return err
+	}
+	q.put(l, data)
+	return nil
+}
+
+// reserve takes a slot in the lane for the message and stores it in the
+// journal, if any. The caller holds closeMu.
+func (q *Queue) reserve(data SharedData) (*lane, SharedData, error) {
+	l := q.lanes[q.laneFor(data.Payload)]
+	if !l.take() {
+		return nil, data, ErrFull
+	}
+	if q.journal != nil {
+		id, err := q.journal.append(data.Payload)
+		if err != nil {
+			l.give()
+			return nil, data, fmt.Errorf("cannot persist message: %w", err)
+		}
+		data.ID = id
+	}
+	return l, data, nil
+}
+
+// put moves a reserved message into its lane. It never blocks, as the slot
+// is already taken. The caller holds closeMu.
+func (q *Queue) put(l *lane, data SharedData) {
+	data.enqueued = time.Now()
+	l.ch <- data
+	l.give()
+	q.signal()
+}
+
+// Complete removes a message from the journal once it needs no further
+// delivery attempts.
+func (q *Queue) Complete(data SharedData) {
+	if q.journal == nil || data.ID == 0 {
+		return
+	}
+	if err := q.journal.ack(data.ID); err != nil {
+		slog.Error("Cannot mark message as delivered in queue journal", "id", data.ID, "error", err)
+	}
+}
+
+// Pending returns the number of journaled messages not yet completed.
+func (q *Queue) Pending() int {
+	if q.journal == nil {
+		pending := 0
+		for _, l := range q.lanes {
+			pending += len(l.ch)
+		}
+		return pending
+	}
+	return q.journal.len()
+}
+
+// Close closes the channels in the queue and the journal. Enqueue fails with ErrClosed afterwards.
 func (q *Queue) Close() {
-	q.closeOnce.Do(func() {
-		close(q.DataChannel)
-	})
+	q.closeMu.Lock()
+	defer q.closeMu.Unlock()
+	if q.closed {
+		return
+	}
+	q.closed = true
+
+	for _, l := range q.lanes {
+		close(l.ch)
+	}
+	close(q.notify)
+	if q.journal != nil {
+		if err := q.journal.close(); err != nil {
+			slog.Error("Error closing queue journal", "error", err)
+		}
+	}
 }
 
 func (q *Queue) UpdateStartTime() {
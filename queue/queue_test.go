@@ -1,7 +1,14 @@
 package queue
 
 import (
+	"cid_retranslator/config"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
 	"testing"
+	"time"
 )
 
 func TestNew(t *testing.T) {
@@ -28,3 +35,479 @@ func TestQueue_Close(t *testing.T) {
 	// Closing a second time should not panic
 	q.Close()
 }
+
+func TestQueue_EnqueueAfterClose(t *testing.T) {
+	q, err := Open(&config.QueueConfig{BufferSize: 1000, Dir: t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Handlers still relaying while the queue closes must not panic.
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				if err := q.Enqueue(SharedData{Payload: []byte("msg")}); err != nil && !errors.Is(err, ErrClosed) {
+					t.Errorf("Enqueue() unexpected error: %v", err)
+					return
+				}
+			}
+		}()
+	}
+	q.Close()
+	wg.Wait()
+
+	if err := q.Enqueue(SharedData{Payload: []byte("late")}); !errors.Is(err, ErrClosed) {
+		t.Errorf("Enqueue() after Close = %v, want ErrClosed", err)
+	}
+}
+
+func drain(q *Queue) []SharedData {
+	var out []SharedData
+	for {
+		select {
+		case d := <-q.DataChannel:
+			out = append(out, d)
+		default:
+			return out
+		}
+	}
+}
+
+func TestOpen_RecoversPending(t *testing.T) {
+	cfg := &config.QueueConfig{BufferSize: 10, Dir: t.TempDir()}
+
+	q, err := Open(cfg)
+	if err != nil {
+		t.Fatalf("Open() unexpected error: %v", err)
+	}
+	for _, p := range []string{"first", "second", "third"} {
+		if err := q.Enqueue(SharedData{Payload: []byte(p)}); err != nil {
+			t.Fatalf("Enqueue() unexpected error: %v", err)
+		}
+	}
+	queued := drain(q)
+	q.Complete(queued[1])
+	if got := q.Pending(); got != 2 {
+		t.Errorf("Pending() = %d, want 2", got)
+	}
+	q.Close()
+
+	q, err = Open(cfg)
+	if err != nil {
+		t.Fatalf("reopen unexpected error: %v", err)
+	}
+	defer q.Close()
+
+	recovered := drain(q)
+	if len(recovered) != 2 || string(recovered[0].Payload) != "first" || string(recovered[1].Payload) != "third" {
+		t.Fatalf("recovered = %+v", recovered)
+	}
+	if recovered[0].ReplyCh != nil {
+		t.Error("recovered message should have no reply channel")
+	}
+
+	// New messages must not reuse recovered IDs.
+	if err := q.Enqueue(SharedData{Payload: []byte("fourth")}); err != nil {
+		t.Fatal(err)
+	}
+	if d := drain(q); d[0].ID <= recovered[1].ID {
+		t.Errorf("new ID %d not after recovered ID %d", d[0].ID, recovered[1].ID)
+	}
+}
+
+func TestOpen_TruncatesTornRecord(t *testing.T) {
+	cfg := &config.QueueConfig{BufferSize: 10, Dir: t.TempDir(), Fsync: config.FsyncNever}
+
+	q, err := Open(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q.Enqueue(SharedData{Payload: []byte("kept")})
+	q.Close()
+
+	path := filepath.Join(cfg.Dir, journalFile)
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write(encodeRecord(recordPut, 99, []byte("torn"))[:10])
+	f.Close()
+
+	q, err = Open(cfg)
+	if err != nil {
+		t.Fatalf("Open() with torn tail unexpected error: %v", err)
+	}
+	recovered := drain(q)
+	if len(recovered) != 1 || string(recovered[0].Payload) != "kept" {
+		t.Fatalf("recovered = %+v", recovered)
+	}
+
+	// Appends after the truncated tail must be readable again.
+	q.Enqueue(SharedData{Payload: []byte("after")})
+	q.Close()
+	q, err = Open(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+	if got := len(drain(q)); got != 2 {
+		t.Errorf("recovered %d messages after torn tail, want 2", got)
+	}
+}
+
+func TestQueue_Compaction(t *testing.T) {
+	cfg := &config.QueueConfig{BufferSize: 100, Dir: t.TempDir(), CompactAfter: 10}
+
+	q, err := Open(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 11; i++ {
+		q.Enqueue(SharedData{Payload: []byte("5000 181234E60100000\x14")})
+	}
+	queued := drain(q)
+	for _, d := range queued[:10] {
+		q.Complete(d)
+	}
+	q.Close()
+
+	info, err := os.Stat(filepath.Join(cfg.Dir, journalFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := int64(len(encodeRecord(recordPut, 1, queued[10].Payload)))
+	if info.Size() != want {
+		t.Errorf("journal size after compaction = %d, want %d", info.Size(), want)
+	}
+
+	q, err = Open(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+	if recovered := drain(q); len(recovered) != 1 || recovered[0].ID != queued[10].ID {
+		t.Errorf("recovered = %+v", recovered)
+	}
+}
+
+func TestJournal_CompactRepeatedly(t *testing.T) {
+	dir := t.TempDir()
+	j, _, err := openJournal(dir, config.FsyncAlways, 0, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	payload := []byte("5000 181234E60100000\x14")
+	var kept uint64
+	for round := 0; round < 5; round++ {
+		for i := 0; i < 3; i++ {
+			id, err := j.append(payload)
+			if err != nil {
+				t.Fatalf("round %d: append() error: %v", round, err)
+			}
+			j.ack(id)
+		}
+		// Appends after a compaction go to the reopened file.
+		if kept, err = j.append(payload); err != nil {
+			t.Fatalf("round %d: append() after compaction error: %v", round, err)
+		}
+		if j.acked != 0 {
+			t.Fatalf("round %d: acked = %d after compaction, want 0", round, j.acked)
+		}
+	}
+	j.close()
+
+	info, err := os.Stat(filepath.Join(dir, journalFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if max := int64(5 * len(encodeRecord(recordPut, 1, payload))); info.Size() > max {
+		t.Errorf("journal size = %d, want at most %d", info.Size(), max)
+	}
+
+	j, recovered, err := openJournal(dir, config.FsyncAlways, 0, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer j.close()
+	if len(recovered) != 5 || recovered[4].id != kept {
+		t.Errorf("recovered %d messages, last %d, want 5 ending with %d", len(recovered), recovered[len(recovered)-1].id, kept)
+	}
+}
+
+func TestJournal_CompactFailure(t *testing.T) {
+	dir := t.TempDir()
+	j, _, err := openJournal(dir, config.FsyncAlways, 0, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer j.close()
+	// A directory in place of the snapshot file makes compaction fail.
+	if err := os.Mkdir(filepath.Join(dir, journalFile+".tmp"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		id, _ := j.append([]byte("msg"))
+		if err := j.ack(id); err != nil {
+			t.Fatalf("ack() error: %v", err)
+		}
+	}
+	if j.acked != 0 {
+		t.Errorf("acked = %d after failed compaction, want 0", j.acked)
+	}
+	if _, err := j.append([]byte("after")); err != nil {
+		t.Errorf("append() after failed compaction error: %v", err)
+	}
+}
+
+func TestQueue_EnqueueFull(t *testing.T) {
+	cfg := &config.QueueConfig{BufferSize: 1, Dir: t.TempDir()}
+	q, err := Open(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+
+	if err := q.Enqueue(SharedData{Payload: []byte("a")}); err != nil {
+		t.Fatal(err)
+	}
+	if err := q.Enqueue(SharedData{Payload: []byte("b")}); err != ErrFull {
+		t.Errorf("Enqueue() on full queue error = %v, want ErrFull", err)
+	}
+	if got := q.Pending(); got != 1 {
+		t.Errorf("Pending() = %d, want 1", got)
+	}
+}
+
+func TestQueue_PopPriority(t *testing.T) {
+	alarm, _ := config.ParseRange("100-199")
+	trouble, _ := config.ParseRange("300-399")
+	q, err := Open(&config.QueueConfig{
+		BufferSize: 10,
+		Priorities: []config.PriorityClass{
+			{Name: "alarm", Codes: []config.Range{alarm}},
+			{Name: "trouble", Codes: []config.Range{trouble}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+
+	for _, p := range []string{
+		"5000 181234E60200000\x14",
+		"5000 181234E30100001\x14",
+		"5000 181234E13001002\x14",
+		"5000 181234E60300000\x14",
+		"not a contact id frame",
+	} {
+		if err := q.Enqueue(SharedData{Payload: []byte(p)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	stats := q.LaneStats()
+	if len(stats) != 3 || stats[0].Depth != 1 || stats[1].Depth != 1 || stats[2].Name != config.DefaultLane || stats[2].Depth != 3 {
+		t.Fatalf("LaneStats() = %+v", stats)
+	}
+
+	want := []string{
+		"5000 181234E13001002\x14",
+		"5000 181234E30100001\x14",
+		"5000 181234E60200000\x14",
+		"5000 181234E60300000\x14",
+		"not a contact id frame",
+	}
+	for _, w := range want {
+		data, err := q.Pop(context.Background())
+		if err != nil {
+			t.Fatalf("Pop() unexpected error: %v", err)
+		}
+		if string(data.Payload) != w {
+			t.Errorf("Pop() = %q, want %q", data.Payload, w)
+		}
+	}
+	if got := q.LaneStats()[2].Popped; got != 3 {
+		t.Errorf("default lane popped = %d, want 3", got)
+	}
+}
+
+func TestQueue_PopBlocks(t *testing.T) {
+	q := New(1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := q.Pop(ctx); err != context.DeadlineExceeded {
+		t.Errorf("Pop() on empty queue error = %v, want DeadlineExceeded", err)
+	}
+
+	go q.Enqueue(SharedData{Payload: []byte("late")})
+	data, err := q.Pop(context.Background())
+	if err != nil || string(data.Payload) != "late" {
+		t.Errorf("Pop() = %q, %v", data.Payload, err)
+	}
+
+	q.Close()
+	if _, err := q.Pop(context.Background()); err != ErrClosed {
+		t.Errorf("Pop() after Close error = %v, want ErrClosed", err)
+	}
+}
+
+func TestQueue_TryPop(t *testing.T) {
+	q := New(1)
+
+	if _, err := q.TryPop(); err != ErrEmpty {
+		t.Errorf("TryPop() on empty queue error = %v, want ErrEmpty", err)
+	}
+
+	q.Enqueue(SharedData{Payload: []byte("one")})
+	select {
+	case <-q.Ready():
+	default:
+		t.Error("Ready() not signalled after Enqueue")
+	}
+	data, err := q.TryPop()
+	if err != nil || string(data.Payload) != "one" {
+		t.Errorf("TryPop() = %q, %v", data.Payload, err)
+	}
+
+	q.Close()
+	if _, err := q.TryPop(); err != ErrClosed {
+		t.Errorf("TryPop() after Close error = %v, want ErrClosed", err)
+	}
+}
+
+func TestQueue_Sweep(t *testing.T) {
+	alarm, _ := config.ParseRange("100-199")
+	q, err := Open(&config.QueueConfig{
+		BufferSize: 10,
+		Priorities: []config.PriorityClass{{Name: "alarm", Codes: []config.Range{alarm}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+
+	for _, p := range []string{
+		"5000 181234E60200000\x14",
+		"5000 181234E13001001\x14",
+		"5000 181234E60300000\x14",
+		"5000 181234E13001002\x14",
+	} {
+		if err := q.Enqueue(SharedData{Payload: []byte(p)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	dropped := q.Sweep(func(data *SharedData) bool {
+		data.Attempts++
+		return string(data.Payload) != "5000 181234E60300000\x14"
+	})
+	if len(dropped) != 1 || string(dropped[0].Payload) != "5000 181234E60300000\x14" {
+		t.Errorf("Sweep() = %+v, want the 603 event", dropped)
+	}
+
+	want := []string{
+		"5000 181234E13001001\x14",
+		"5000 181234E13001002\x14",
+		"5000 181234E60200000\x14",
+	}
+	for _, w := range want {
+		data, err := q.TryPop()
+		if err != nil || string(data.Payload) != w || data.Attempts != 1 {
+			t.Errorf("TryPop() = %q (attempts %d), %v, want %q", data.Payload, data.Attempts, err, w)
+		}
+	}
+	if _, err := q.TryPop(); err != ErrEmpty {
+		t.Errorf("TryPop() after the swept messages error = %v, want ErrEmpty", err)
+	}
+}
+
+func TestQueue_DeadLetters(t *testing.T) {
+	cfg := &config.QueueConfig{BufferSize: 10, Dir: t.TempDir(), MaxDeadLetters: 2}
+	q, err := Open(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, p := range []string{"one", "two", "three"} {
+		q.Enqueue(SharedData{Payload: []byte(p)})
+	}
+	for _, d := range drain(q) {
+		q.DeadLetter(d, "rejected by receiver: NAK", 3)
+	}
+	if got := q.Pending(); got != 0 {
+		t.Errorf("Pending() after dead-lettering = %d, want 0", got)
+	}
+	q.Close()
+
+	q, err = Open(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+
+	dead := q.DeadLetters()
+	if len(dead) != 2 || dead[0].Payload != "two" || dead[1].Attempts != 3 || dead[1].Reason == "" {
+		t.Fatalf("DeadLetters() after reopen = %+v", dead)
+	}
+
+	if err := q.Replay(dead[0].ID); err != nil {
+		t.Fatalf("Replay() unexpected error: %v", err)
+	}
+	if err := q.Replay(dead[0].ID); err != ErrNotFound {
+		t.Errorf("second Replay() error = %v, want ErrNotFound", err)
+	}
+	if replayed := drain(q); len(replayed) != 1 || string(replayed[0].Payload) != "two" || replayed[0].ReplyCh != nil {
+		t.Errorf("replayed = %+v", replayed)
+	}
+
+	if n := q.PurgeDeadLetters(); n != 1 || q.DeadLetterCount() != 0 {
+		t.Errorf("PurgeDeadLetters() = %d, left %d", n, q.DeadLetterCount())
+	}
+}
+
+func TestQueue_Reserve(t *testing.T) {
+	q, err := Open(&config.QueueConfig{Dir: t.TempDir(), BufferSize: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+
+	r, err := q.Reserve([]byte("first"), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The reserved slot counts against the buffer before it is committed.
+	if err := q.Enqueue(SharedData{Payload: []byte("second")}); err != ErrFull {
+		t.Fatalf("Enqueue() = %v, want ErrFull", err)
+	}
+	if _, err := q.TryPop(); err != ErrEmpty {
+		t.Fatalf("TryPop() = %v, reserved message must not be visible", err)
+	}
+
+	r.Release()
+	if n := q.Pending(); n != 0 {
+		t.Fatalf("Pending() = %d after Release, want 0", n)
+	}
+
+	r, err = q.Reserve([]byte("third"), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	data, err := q.TryPop()
+	if err != nil || string(data.Payload) != "third" {
+		t.Fatalf("TryPop() = %q, %v, want third", data.Payload, err)
+	}
+	data.Reply(DeliveryData{Status: true, Response: "ACK"})
+	if reply := <-r.ReplyCh(); !reply.Status {
+		t.Errorf("ReplyCh() = %+v, want the consumer's ACK", reply)
+	}
+}
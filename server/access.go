@@ -0,0 +1,211 @@
+package server
+
+import (
+	"cid_retranslator/config"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/netip"
+	"sync"
+	"time"
+)
+
+// maxBuckets is the number of rate-limit buckets kept before idle ones are
+// dropped.
+const maxBuckets = 1024
+
+// access enforces the source lists and limits of the listener. The settings
+// can be replaced while the server runs.
+type access struct {
+	mu      sync.Mutex
+	cfg     config.AccessConfig
+	allow   []netip.Prefix
+	deny    []netip.Prefix
+	buckets map[netip.Addr]*bucket
+
+	denied      int64 // connections and datagrams from sources not allowed
+	overLimit   int64 // connections above MaxConnections
+	rateLimited int64 // messages NACKed by the rate limit
+}
+
+// bucket is the token bucket of one source address.
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// AccessStats counts what the access control rejected.
+type AccessStats struct {
+	Denied      int64 `json:"denied"`
+	OverLimit   int64 `json:"overLimit"`
+	RateLimited int64 `json:"rateLimited"`
+}
+
+func newAccess(cfg config.AccessConfig) *access {
+	a := &access{buckets: make(map[netip.Addr]*bucket)}
+	if err := a.set(cfg); err != nil {
+		// Validated with the configuration; only reachable with hand-built settings.
+		slog.Error("Invalid access settings, allowing all sources", "error", err)
+	}
+	return a
+}
+
+func (a *access) set(cfg config.AccessConfig) error {
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+	allow, _ := config.ParsePrefixes(cfg.Allow)
+	deny, _ := config.ParsePrefixes(cfg.Deny)
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.cfg = cfg
+	a.allow, a.deny = allow, deny
+	clear(a.buckets)
+	return nil
+}
+
+// permitted reports whether the lists let ip in. The caller holds mu.
+func (a *access) permitted(ip netip.Addr) bool {
+	for _, p := range a.deny {
+		if p.Contains(ip) {
+			return false
+		}
+	}
+	if len(a.allow) == 0 {
+		return true
+	}
+	for _, p := range a.allow {
+		if p.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// check reports whether the lists let addr in, without counting.
+func (a *access) check(addr net.Addr) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.permitted(ipOf(addr))
+}
+
+// admit checks a new connection with open connections already accepted and
+// returns why it is rejected, or "".
+func (a *access) admit(addr net.Addr, open int) string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if !a.permitted(ipOf(addr)) {
+		a.denied++
+		return "source not allowed"
+	}
+	if a.cfg.MaxConnections > 0 && open >= a.cfg.MaxConnections {
+		a.overLimit++
+		return fmt.Sprintf("connection limit %d reached", a.cfg.MaxConnections)
+	}
+	return ""
+}
+
+// admitDatagram checks the source of a UDP datagram.
+func (a *access) admitDatagram(addr net.Addr) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if !a.permitted(ipOf(addr)) {
+		a.denied++
+		return false
+	}
+	return true
+}
+
+// allowMessage takes a token from the bucket of the source and reports
+// whether the message is within the rate limit.
+func (a *access) allowMessage(addr net.Addr) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.cfg.RateLimit <= 0 {
+		return true
+	}
+	burst := float64(a.cfg.RateBurst)
+	if burst <= 0 {
+		burst = max(a.cfg.RateLimit, 1)
+	}
+
+	now := time.Now()
+	ip := ipOf(addr)
+	b, ok := a.buckets[ip]
+	if !ok {
+		if len(a.buckets) >= maxBuckets {
+			a.sweep(now, burst)
+		}
+		b = &bucket{tokens: burst, last: now}
+		a.buckets[ip] = b
+	}
+	b.tokens = min(burst, b.tokens+now.Sub(b.last).Seconds()*a.cfg.RateLimit)
+	b.last = now
+	if b.tokens < 1 {
+		a.rateLimited++
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// sweep drops the buckets that have refilled completely. The caller holds mu.
+func (a *access) sweep(now time.Time, burst float64) {
+	for ip, b := range a.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*a.cfg.RateLimit >= burst {
+			delete(a.buckets, ip)
+		}
+	}
+}
+
+// ipOf returns the IP address of a TCP or UDP peer.
+func ipOf(addr net.Addr) netip.Addr {
+	switch a := addr.(type) {
+	case *net.TCPAddr:
+		return a.AddrPort().Addr().Unmap()
+	case *net.UDPAddr:
+		return a.AddrPort().Addr().Unmap()
+	}
+	ap, err := netip.ParseAddrPort(addr.String())
+	if err != nil {
+		return netip.Addr{}
+	}
+	return ap.Addr().Unmap()
+}
+
+// Access returns the current access settings.
+func (server *Server) Access() config.AccessConfig {
+	server.access.mu.Lock()
+	defer server.access.mu.Unlock()
+	return server.access.cfg
+}
+
+// SetAccess replaces the access settings and closes the open connections of
+// sources that are no longer allowed.
+func (server *Server) SetAccess(cfg config.AccessConfig) error {
+	if err := server.access.set(cfg); err != nil {
+		return err
+	}
+
+	server.connMu.Lock()
+	defer server.connMu.Unlock()
+	for _, c := range server.conns {
+		if !server.access.check(c.conn.RemoteAddr()) {
+			slog.Warn("Closing connection from source no longer allowed", "from", c.conn.RemoteAddr())
+			c.conn.Close()
+		}
+	}
+	return nil
+}
+
+// GetAccessStats returns the rejection counters.
+func (server *Server) GetAccessStats() AccessStats {
+	server.access.mu.Lock()
+	defer server.access.mu.Unlock()
+	return AccessStats{
+		Denied:      server.access.denied,
+		OverLimit:   server.access.overLimit,
+		RateLimited: server.access.rateLimited,
+	}
+}
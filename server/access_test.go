@@ -0,0 +1,113 @@
+package server
+
+import (
+	"cid_retranslator/config"
+	"net"
+	"testing"
+)
+
+func TestAccess_Admit(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  config.AccessConfig
+		addr string
+		open int
+		want bool
+	}{
+		{"no lists", config.AccessConfig{}, "192.0.2.1", 0, true},
+		{"allowed", config.AccessConfig{Allow: []string{"192.0.2.0/24"}}, "192.0.2.1", 0, true},
+		{"not in allow list", config.AccessConfig{Allow: []string{"192.0.2.0/24"}}, "198.51.100.1", 0, false},
+		{"denied", config.AccessConfig{Deny: []string{"192.0.2.1"}}, "192.0.2.1", 0, false},
+		{"deny wins over allow", config.AccessConfig{Allow: []string{"192.0.2.0/24"}, Deny: []string{"192.0.2.1"}}, "192.0.2.1", 0, false},
+		{"mapped IPv4", config.AccessConfig{Allow: []string{"192.0.2.0/24"}}, "::ffff:192.0.2.1", 0, true},
+		{"below limit", config.AccessConfig{MaxConnections: 2}, "192.0.2.1", 1, true},
+		{"at limit", config.AccessConfig{MaxConnections: 2}, "192.0.2.1", 2, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := newAccess(tt.cfg)
+			addr := &net.TCPAddr{IP: net.ParseIP(tt.addr), Port: 1234}
+			if got := a.admit(addr, tt.open) == ""; got != tt.want {
+				t.Errorf("admit(%s) = %v, want %v", tt.addr, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAccess_RateLimit(t *testing.T) {
+	a := newAccess(config.AccessConfig{RateLimit: 0.01, RateBurst: 2})
+	other := &net.TCPAddr{IP: net.IPv4(192, 0, 2, 2), Port: 1234}
+
+	for i, want := range []bool{true, true, false} {
+		if got := a.allowMessage(testAddr); got != want {
+			t.Errorf("message %d allowed = %v, want %v", i+1, got, want)
+		}
+	}
+	if !a.allowMessage(other) {
+		t.Error("another source shares the bucket")
+	}
+	if a.rateLimited != 1 {
+		t.Errorf("rateLimited = %d, want 1", a.rateLimited)
+	}
+}
+
+func TestServer_Access(t *testing.T) {
+	t.Run("denied source", func(t *testing.T) {
+		server := runServer(t, &config.ServerConfig{}, &fakeDispatcher{reply: ack}, testRules())
+		if err := server.SetAccess(config.AccessConfig{Deny: []string{"127.0.0.1"}}); err != nil {
+			t.Fatal(err)
+		}
+		if !closed(dial(t, server)) {
+			t.Error("connection from a denied source was not closed")
+		}
+		if stats := server.GetAccessStats(); stats.Denied != 1 {
+			t.Errorf("stats = %+v, want one denied", stats)
+		}
+	})
+
+	t.Run("connection limit", func(t *testing.T) {
+		server := runServer(t, &config.ServerConfig{Access: config.AccessConfig{MaxConnections: 1}}, &fakeDispatcher{reply: ack}, testRules())
+		first := dial(t, server)
+		if got := exchange(t, first, testMessage); got != 0x06 {
+			t.Fatalf("first connection response = 0x%02X, want ACK", got)
+		}
+		if !closed(dial(t, server)) {
+			t.Error("connection above the limit was not closed")
+		}
+		if got := exchange(t, first, testMessage); got != 0x06 {
+			t.Errorf("first connection response = 0x%02X after rejection, want ACK", got)
+		}
+		if stats := server.GetAccessStats(); stats.OverLimit != 1 {
+			t.Errorf("stats = %+v, want one over the limit", stats)
+		}
+	})
+
+	t.Run("rate limit", func(t *testing.T) {
+		dispatcher := &fakeDispatcher{reply: ack}
+		server := New(&config.ServerConfig{Access: config.AccessConfig{RateLimit: 0.01, RateBurst: 1}}, dispatcher, testRules())
+		conn := serve(t, server)
+		for i, want := range []byte{0x06, 0x15} {
+			if got := exchange(t, conn, testMessage); got != want {
+				t.Errorf("message %d response = 0x%02X, want 0x%02X", i+1, got, want)
+			}
+		}
+		if n := dispatcher.count(); n != 1 {
+			t.Errorf("dispatched %d messages, want 1", n)
+		}
+	})
+
+	t.Run("revoked while connected", func(t *testing.T) {
+		server := runServer(t, &config.ServerConfig{}, &fakeDispatcher{reply: ack}, testRules())
+		conn := dial(t, server)
+		if got := exchange(t, conn, testMessage); got != 0x06 {
+			t.Fatalf("response = 0x%02X, want ACK", got)
+		}
+		if err := server.SetAccess(config.AccessConfig{Allow: []string{"192.0.2.0/24"}}); err != nil {
+			t.Fatal(err)
+		}
+		if !closed(conn) {
+			t.Error("connection from a source no longer allowed was not closed")
+		}
+	})
+}
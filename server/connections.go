@@ -0,0 +1,236 @@
+package server
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+// shutdownGrace is how long Run waits for connection handlers after closing
+// their connections. Relays stop waiting for replies on shutdown, so this
+// only covers handlers finishing up.
+const shutdownGrace = 2 * time.Second
+
+// connIDs numbers inbound connections across all listeners, so an ID
+// identifies a connection in the whole application.
+var connIDs atomic.Uint64
+
+// Heartbeat reports the last link test received on a connection.
+type Heartbeat struct {
+	ConnectionID  uint64 `json:"connectionID"`
+	Listener      string `json:"listener"`
+	RemoteAddr    string `json:"remoteAddr"`
+	ConnectedAt   string `json:"connectedAt"`
+	LastHeartbeat string `json:"lastHeartbeat"`
+}
+
+// ConnectionInfo describes an open inbound connection.
+type ConnectionInfo struct {
+	ID            uint64 `json:"id"`
+	Listener      string `json:"listener"`
+	RemoteAddr    string `json:"remoteAddr"`
+	ConnectedAt   string `json:"connectedAt"`
+	BytesIn       int64  `json:"bytesIn"`
+	MessagesIn    int64  `json:"messagesIn"`
+	LastMessage   string `json:"lastMessage"`
+	LastHeartbeat string `json:"lastHeartbeat"`
+}
+
+// ErrUnknownConnection is returned by Disconnect for an ID that is not open.
+var ErrUnknownConnection = errors.New("unknown connection")
+
+// track registers a new inbound connection.
+func (server *Server) track(conn net.Conn) *connection {
+	server.connMu.Lock()
+	defer server.connMu.Unlock()
+
+	c := &connection{
+		id:          connIDs.Add(1),
+		conn:        conn,
+		server:      server,
+		connectedAt: time.Now(),
+	}
+	server.conns[c.id] = c
+	return c
+}
+
+// untrack removes a closed connection.
+func (server *Server) untrack(c *connection) {
+	server.connMu.Lock()
+	delete(server.conns, c.id)
+	server.connMu.Unlock()
+}
+
+// closeConnections closes every open connection and waits for the handlers
+// to finish.
+func (server *Server) closeConnections() {
+	server.connMu.Lock()
+	for _, c := range server.conns {
+		c.conn.Close()
+	}
+	server.connMu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		server.handlers.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(shutdownGrace):
+		slog.Warn("Connection handlers still busy after shutdown grace period")
+	}
+}
+
+// awaitFrame waits up to the idle timeout for the next frame to start and
+// then gives the rest of the frame the read timeout.
+func (c *connection) awaitFrame(reader *bufio.Reader) error {
+	if c.server.idleTimeout > 0 {
+		c.conn.SetReadDeadline(time.Now().Add(c.server.idleTimeout))
+	}
+	if _, err := reader.Peek(1); err != nil {
+		return err
+	}
+
+	deadline := time.Time{}
+	if c.server.readTimeout > 0 {
+		deadline = time.Now().Add(c.server.readTimeout)
+	}
+	return c.conn.SetReadDeadline(deadline)
+}
+
+// readFailed logs why reading from the connection stopped. idle tells
+// whether it happened between frames.
+func (c *connection) readFailed(err error, idle bool) {
+	remoteAddr := c.conn.RemoteAddr()
+	switch {
+	case err == io.EOF:
+		slog.Info("Connection closed by client", "client", remoteAddr)
+	case errors.Is(err, net.ErrClosed):
+		slog.Info("Connection closed", "client", remoteAddr)
+	case errors.Is(err, os.ErrDeadlineExceeded) && idle:
+		slog.Info("Closing idle connection", "client", remoteAddr, "idle", c.server.idleTimeout)
+	case errors.Is(err, os.ErrDeadlineExceeded):
+		slog.Warn("Incomplete frame, closing connection", "client", remoteAddr, "timeout", c.server.readTimeout)
+	default:
+		slog.Error("Read error", "from", remoteAddr, "error", err)
+	}
+}
+
+// connCount returns the number of open connections.
+func (server *Server) connCount() int {
+	server.connMu.Lock()
+	defer server.connMu.Unlock()
+	return len(server.conns)
+}
+
+// isHeartbeat reports whether the frame is a configured link-test frame.
+func (server *Server) isHeartbeat(message []byte) bool {
+	frame := string(message)
+	if len(frame) > 0 && frame[len(frame)-1] == 0x14 {
+		frame = frame[:len(frame)-1]
+	}
+	for _, re := range server.heartbeats {
+		if re.MatchString(frame) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *connection) recordHeartbeat() {
+	c.mu.Lock()
+	c.lastHeartbeat = time.Now()
+	c.mu.Unlock()
+}
+
+func (c *connection) recordMessage() {
+	c.mu.Lock()
+	c.messagesIn++
+	c.lastMessage = time.Now()
+	c.mu.Unlock()
+}
+
+// Read reads from the connection and counts the bytes received.
+func (c *connection) Read(p []byte) (int, error) {
+	n, err := c.conn.Read(p)
+	c.mu.Lock()
+	c.bytesIn += int64(n)
+	c.mu.Unlock()
+	return n, err
+}
+
+func (c *connection) info() ConnectionInfo {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return ConnectionInfo{
+		ID:            c.id,
+		Listener:      c.server.name,
+		RemoteAddr:    c.conn.RemoteAddr().String(),
+		ConnectedAt:   formatTime(c.connectedAt),
+		BytesIn:       c.bytesIn,
+		MessagesIn:    c.messagesIn,
+		LastMessage:   formatTime(c.lastMessage),
+		LastHeartbeat: formatTime(c.lastHeartbeat),
+	}
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format("2006-01-02 15:04:05")
+}
+
+// GetConnections returns every open inbound connection, oldest first.
+func (server *Server) GetConnections() []ConnectionInfo {
+	server.connMu.Lock()
+	conns := make([]*connection, 0, len(server.conns))
+	for _, c := range server.conns {
+		conns = append(conns, c)
+	}
+	server.connMu.Unlock()
+
+	sort.Slice(conns, func(i, j int) bool { return conns[i].id < conns[j].id })
+
+	infos := make([]ConnectionInfo, len(conns))
+	for i, c := range conns {
+		infos[i] = c.info()
+	}
+	return infos
+}
+
+// GetHeartbeats returns the last heartbeat time of every open connection
+func (server *Server) GetHeartbeats() []Heartbeat {
+	conns := server.GetConnections()
+	heartbeats := make([]Heartbeat, len(conns))
+	for i, c := range conns {
+		heartbeats[i] = Heartbeat{
+			ConnectionID:  c.ID,
+			Listener:      c.Listener,
+			RemoteAddr:    c.RemoteAddr,
+			ConnectedAt:   c.ConnectedAt,
+			LastHeartbeat: c.LastHeartbeat,
+		}
+	}
+	return heartbeats
+}
+
+// Disconnect closes an open connection. The sender may connect again unless
+// the access lists deny it.
+func (server *Server) Disconnect(id uint64) error {
+	server.connMu.Lock()
+	c, ok := server.conns[id]
+	server.connMu.Unlock()
+	if !ok {
+		return ErrUnknownConnection
+	}
+	slog.Info("Disconnecting connection", "id", id, "from", c.conn.RemoteAddr())
+	return c.conn.Close()
+}
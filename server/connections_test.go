@@ -0,0 +1,115 @@
+package server
+
+import (
+	"cid_retranslator/config"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestConnection_Deadlines(t *testing.T) {
+	tests := []struct {
+		name    string
+		idle    time.Duration
+		read    time.Duration
+		send    string
+		wantAck bool // the frame is answered before the connection closes
+	}{
+		{"idle", 50 * time.Millisecond, 0, "", false},
+		{"incomplete frame", time.Minute, 50 * time.Millisecond, "5000 18", false},
+		{"idle after a frame", 50 * time.Millisecond, time.Minute, testMessage, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &config.ServerConfig{IdleTimeout: tt.idle, ReadTimeout: tt.read}
+			server := New(cfg, &fakeDispatcher{reply: ack}, testRules())
+			conn := serve(t, server)
+
+			if tt.send != "" {
+				if _, err := conn.Write([]byte(tt.send)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if tt.wantAck {
+				buf := make([]byte, 1)
+				if _, err := conn.Read(buf); err != nil || buf[0] != 0x06 {
+					t.Fatalf("response = 0x%02X, %v, want ACK", buf[0], err)
+				}
+			}
+			if !closed(conn) {
+				t.Error("connection still open after the deadline")
+			}
+			eventually(t, func() bool { return server.connCount() == 0 })
+		})
+	}
+}
+
+func TestServer_ConnectionRegistry(t *testing.T) {
+	server := runServer(t, &config.ServerConfig{Name: "alarm"}, &fakeDispatcher{reply: ack}, testRules())
+	conn := dial(t, server)
+	if got := exchange(t, conn, testMessage); got != 0x06 {
+		t.Fatalf("response = 0x%02X, want ACK", got)
+	}
+
+	conns := server.GetConnections()
+	if len(conns) != 1 {
+		t.Fatalf("GetConnections() = %+v, want one", conns)
+	}
+	info := conns[0]
+	if info.Listener != "alarm" || info.RemoteAddr != conn.LocalAddr().String() ||
+		info.BytesIn != int64(len(testMessage)) || info.MessagesIn != 1 || info.LastMessage == "" {
+		t.Errorf("connection = %+v", info)
+	}
+
+	if err := server.Disconnect(info.ID); err != nil {
+		t.Fatalf("Disconnect() error: %v", err)
+	}
+	if !closed(conn) {
+		t.Error("connection still open after Disconnect()")
+	}
+	eventually(t, func() bool { return len(server.GetConnections()) == 0 })
+	if err := server.Disconnect(info.ID); !errors.Is(err, ErrUnknownConnection) {
+		t.Errorf("Disconnect() of a closed connection = %v, want ErrUnknownConnection", err)
+	}
+}
+
+func TestServer_StopClosesConnections(t *testing.T) {
+	server := runServer(t, &config.ServerConfig{}, &fakeDispatcher{reply: ack}, testRules())
+	conn := dial(t, server)
+	if got := exchange(t, conn, testMessage); got != 0x06 {
+		t.Fatalf("response = 0x%02X, want ACK", got)
+	}
+
+	server.Stop()
+	if !closed(conn) {
+		t.Error("connection still open after Stop()")
+	}
+}
+
+func TestServer_StopCancelsRelays(t *testing.T) {
+	// A relay waiting for the downstream reply gives up on shutdown instead
+	// of holding the handler until the reply timeout.
+	dispatcher := &fakeDispatcher{hold: true}
+	server := runServer(t, &config.ServerConfig{ReplyTimeout: time.Minute}, dispatcher, testRules())
+	conn := dial(t, server)
+	if _, err := conn.Write([]byte(testMessage)); err != nil {
+		t.Fatal(err)
+	}
+	eventually(t, func() bool { return dispatcher.count() == 1 })
+
+	server.Stop()
+	done := make(chan struct{})
+	go func() {
+		server.handlers.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(shutdownGrace):
+		t.Fatal("connection handler still relaying after the shutdown grace period")
+	}
+	if !closed(conn) {
+		t.Error("connection answered after Stop(), want it closed without a reply")
+	}
+}
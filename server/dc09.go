@@ -0,0 +1,198 @@
+package server
+
+import (
+	"bufio"
+	"cid_retranslator/dc09"
+	"context"
+	"log/slog"
+	"net"
+	"time"
+)
+
+// Encrypted messages must carry a timestamp within this window of the
+// receiver clock, which protects against replayed frames.
+const (
+	dc09MaxDelay = 40 * time.Second
+	dc09MaxAhead = 20 * time.Second
+)
+
+// udpWorkers bounds the datagrams handled at once. Further datagrams wait in
+// the socket buffer until a worker is free.
+const udpWorkers = 64
+
+// handleDC09 serves a TCP connection speaking SIA DC-09.
+func (c *connection) handleDC09(ctx context.Context) {
+	remoteAddr := c.conn.RemoteAddr()
+	slog.Debug("Handling DC-09 connection", "from", remoteAddr)
+	defer c.server.handlers.Done()
+	defer c.server.untrack(c)
+	defer c.conn.Close()
+
+	reader := bufio.NewReader(c)
+	for {
+		select {
+		case <-ctx.Done():
+			slog.Info("Closing connection due to server shutdown.", "client", remoteAddr)
+			return
+		default:
+		}
+
+		if err := c.awaitFrame(reader); err != nil {
+			c.readFailed(err, true)
+			return
+		}
+		raw, err := dc09.ReadFrame(reader)
+		if err != nil {
+			c.readFailed(err, false)
+			return
+		}
+
+		response := c.server.handleDC09Frame(ctx, remoteAddr, raw, c)
+		if response == nil {
+			return
+		}
+		if _, err := c.conn.Write(response); err != nil {
+			slog.Error("Error sending DC-09 response", "error", err)
+			return
+		}
+	}
+}
+
+// serveDC09UDP answers DC-09 datagrams on the listener port until ctx is
+// done. The caller adds it to server.handlers; every datagram is handled on
+// a worker counted there as well, so shutdown waits for them.
+func (server *Server) serveDC09UDP(ctx context.Context) {
+	defer server.handlers.Done()
+	pc, err := net.ListenPacket("udp", server.Address())
+	if err != nil {
+		slog.Error("Failed to start DC-09 UDP listener", "error", err)
+		return
+	}
+	slog.Info("DC-09 UDP listener started", "listener", server.name, "host", server.host, "port", server.port)
+
+	go func() {
+		<-ctx.Done()
+		pc.Close()
+	}()
+
+	workers := make(chan struct{}, udpWorkers)
+	buf := make([]byte, 4096)
+	for {
+		n, addr, err := pc.ReadFrom(buf)
+		if err != nil {
+			select {
+			case <-ctx.Done():
+				slog.Info("DC-09 UDP listener stopped.")
+				return
+			default:
+				slog.Error("UDP read error", "error", err)
+			}
+			continue
+		}
+
+		if !server.access.admitDatagram(addr) {
+			slog.Warn("Datagram rejected", "from", addr, "reason", "source not allowed")
+			continue
+		}
+		raw := append([]byte(nil), buf[:n]...)
+		select {
+		case workers <- struct{}{}:
+		case <-ctx.Done():
+			return
+		}
+		server.handlers.Add(1)
+		go func() {
+			defer server.handlers.Done()
+			defer func() { <-workers }()
+			response := server.handleDC09Frame(ctx, addr, raw, nil)
+			if response == nil {
+				return
+			}
+			if _, err := pc.WriteTo(response, addr); err != nil {
+				slog.Error("Error sending DC-09 response", "to", addr, "error", err)
+			}
+		}()
+	}
+}
+
+// handleDC09Frame converts a DC-09 frame into a Contact ID frame, relays it
+// and returns the ACK, NAK or DUH response. A nil response means the
+// connection should be dropped. c is nil for UDP datagrams.
+func (server *Server) handleDC09Frame(ctx context.Context, remoteAddr net.Addr, raw []byte, c *connection) []byte {
+	frame, err := dc09.Decode(raw)
+	if err != nil {
+		slog.Warn("Invalid DC-09 frame", "from", remoteAddr, "error", err, "data", string(raw))
+		return dc09.NAK().Encode()
+	}
+	slog.Debug("Received DC-09 frame", "from", remoteAddr, "id", frame.ID, "seq", frame.Sequence, "account", frame.Account, "encrypted", frame.Encrypted)
+
+	// Replies to encrypted messages are encrypted with the same key.
+	var key []byte
+	if frame.Encrypted {
+		var ok bool
+		if key, ok = server.dc09Keys.Lookup(frame.Account); !ok {
+			slog.Warn("No DC-09 key configured for encrypted account, sending NAK", "from", remoteAddr, "account", frame.Account)
+			return dc09.NAK().Encode()
+		}
+		if err := frame.Decrypt(key); err != nil {
+			slog.Warn("DC-09 decryption failed, check the key for this account; sending NAK", "from", remoteAddr, "account", frame.Account, "error", err)
+			return dc09.NAK().Encode()
+		}
+		if skew := time.Since(frame.Timestamp); skew > dc09MaxDelay || skew < -dc09MaxAhead {
+			slog.Warn("DC-09 timestamp outside the accepted window, sending NAK", "from", remoteAddr, "account", frame.Account, "timestamp", frame.Timestamp)
+			return dc09.NAK().Encode()
+		}
+	}
+	reply := func(id string) []byte {
+		response := frame.Reply(id)
+		if key != nil {
+			if err := response.Encrypt(key); err != nil {
+				slog.Error("Cannot encrypt DC-09 response", "account", frame.Account, "error", err)
+				return dc09.NAK().Encode()
+			}
+		}
+		return response.Encode()
+	}
+
+	switch frame.ID {
+	case dc09.IDNull:
+		if c != nil {
+			c.recordHeartbeat()
+		}
+		slog.Debug("Heartbeat acknowledged", "from", remoteAddr)
+		return reply(dc09.IDACK)
+	case dc09.IDCID, dc09.IDSIA:
+		if c != nil {
+			c.recordMessage()
+		}
+	default:
+		slog.Warn("Unsupported DC-09 message type", "from", remoteAddr, "id", frame.ID)
+		return reply(dc09.IDDUH)
+	}
+
+	prefix := byte('5')
+	if server.rules.RequiredPrefix != "" {
+		prefix = server.rules.RequiredPrefix[0]
+	}
+	msg, err := frame.ContactID(prefix)
+	if err != nil {
+		slog.Warn("Cannot convert DC-09 event to Contact ID", "from", remoteAddr, "error", err, "data", frame.Data)
+		return reply(dc09.IDDUH)
+	}
+	messageBytes, err := msg.Encode()
+	if err != nil {
+		slog.Warn("Cannot convert DC-09 event to Contact ID", "from", remoteAddr, "error", err, "data", frame.Data)
+		return reply(dc09.IDDUH)
+	}
+
+	ack, ok := server.relay(ctx, remoteAddr, messageBytes)
+	if !ok {
+		return nil
+	}
+	if !ack {
+		slog.Info("Message relayed", "from", remoteAddr, "status", dc09.IDNAK, "seq", frame.Sequence, "data", string(messageBytes))
+		return dc09.NAK().Encode()
+	}
+	slog.Info("Message relayed", "from", remoteAddr, "status", dc09.IDACK, "seq", frame.Sequence, "data", string(messageBytes))
+	return reply(dc09.IDACK)
+}
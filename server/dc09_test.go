@@ -0,0 +1,193 @@
+package server
+
+import (
+	"bufio"
+	"cid_retranslator/config"
+	"cid_retranslator/dc09"
+	"cid_retranslator/queue"
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+const testKey = "000102030405060708090A0B0C0D0E0F"
+
+// admFrame builds an ADM-CID burglary alarm for group 1, zone 3.
+func admFrame(seq int, account string) *dc09.Frame {
+	return &dc09.Frame{ID: dc09.IDCID, Sequence: seq, Receiver: "1", Line: "1", Account: account, Data: "#" + account + "|1130 01 003"}
+}
+
+// dc09Exchange sends a frame and decodes the response.
+func dc09Exchange(t *testing.T, conn net.Conn, frame *dc09.Frame) *dc09.Frame {
+	t.Helper()
+	return dc09Send(t, conn, frame.Encode())
+}
+
+func dc09Send(t *testing.T, conn net.Conn, raw []byte) *dc09.Frame {
+	t.Helper()
+	if _, err := conn.Write(raw); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	response, err := dc09.ReadFrame(bufio.NewReader(conn))
+	if err != nil {
+		t.Fatalf("read response: %v", err)
+	}
+	reply, err := dc09.Decode(response)
+	if err != nil {
+		t.Fatalf("Decode(%q) error: %v", response, err)
+	}
+	return reply
+}
+
+func encrypted(t *testing.T, f *dc09.Frame) *dc09.Frame {
+	t.Helper()
+	key, _ := dc09.ParseKey(testKey)
+	if err := f.Encrypt(key); err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+func TestHandleDC09(t *testing.T) {
+	badCRC := admFrame(1, "1234").Encode()
+	badCRC[1] ^= 1
+
+	tests := []struct {
+		name        string
+		reply       queue.DeliveryData
+		raw         []byte
+		wantID      string
+		wantSeq     int
+		wantPayload string // empty when nothing is dispatched
+		wantKey     bool   // response encrypted with testKey
+	}{
+		{"ADM-CID", ack, admFrame(7, "1234").Encode(), dc09.IDACK, 7, "5011 181234E13001003\x14", false},
+		{"non-decimal account", ack, admFrame(8, "1A2B").Encode(), dc09.IDDUH, 8, "", false},
+		{"SIA-DCS", ack, (&dc09.Frame{ID: dc09.IDSIA, Sequence: 9, Receiver: "1", Line: "1", Account: "1234", Data: "#1234|Nri1/BA003"}).Encode(), dc09.IDACK, 9, "5011 181234E13001003\x14", false},
+		{"downstream NAK", nack, admFrame(10, "1234").Encode(), dc09.IDNAK, 0, "5011 181234E13001003\x14", false},
+		{"heartbeat", nack, (&dc09.Frame{ID: dc09.IDNull, Sequence: 11, Line: "0", Account: "1234"}).Encode(), dc09.IDACK, 11, "", false},
+		{"unsupported type", ack, (&dc09.Frame{ID: "ADM-FOO", Sequence: 12, Line: "0", Account: "1234"}).Encode(), dc09.IDDUH, 12, "", false},
+		{"unknown event", ack, (&dc09.Frame{ID: dc09.IDSIA, Sequence: 13, Line: "0", Account: "1234", Data: "#1234|NZZ003"}).Encode(), dc09.IDDUH, 13, "", false},
+		{"bad CRC", ack, badCRC, dc09.IDNAK, 0, "", false},
+		{"encrypted", ack, encrypted(t, admFrame(14, "1234")).Encode(), dc09.IDACK, 14, "5011 181234E13001003\x14", true},
+		{"no key for account", ack, encrypted(t, admFrame(15, "0001")).Encode(), dc09.IDNAK, 0, "", false},
+		{"stale timestamp", ack, encrypted(t, func() *dc09.Frame {
+			f := admFrame(16, "1234")
+			f.Timestamp = time.Now().Add(-time.Minute)
+			return f
+		}()).Encode(), dc09.IDNAK, 0, "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dispatcher := &fakeDispatcher{reply: tt.reply}
+			cfg := &config.ServerConfig{
+				Protocol: config.ProtocolDC09,
+				DC09Keys: []config.DC09Key{{Account: "1234", Key: testKey}},
+			}
+			server := New(cfg, dispatcher, testRules())
+			reply := dc09Send(t, serve(t, server), tt.raw)
+
+			if reply.Encrypted != tt.wantKey {
+				t.Fatalf("response encrypted = %v, want %v", reply.Encrypted, tt.wantKey)
+			}
+			if reply.Encrypted {
+				key, _ := dc09.ParseKey(testKey)
+				if err := reply.Decrypt(key); err != nil {
+					t.Fatalf("Decrypt() error: %v", err)
+				}
+			}
+			if reply.ID != tt.wantID || reply.Sequence != tt.wantSeq {
+				t.Errorf("response = %s seq %d, want %s seq %d", reply.ID, reply.Sequence, tt.wantID, tt.wantSeq)
+			}
+			if reply.ID == dc09.IDACK && reply.Account != "1234" {
+				t.Errorf("response account = %q, want the sender's", reply.Account)
+			}
+
+			var got string
+			if payloads := dispatcher.dispatched(); len(payloads) > 0 {
+				got = payloads[0]
+			}
+			if got != tt.wantPayload {
+				t.Errorf("dispatched %q, want %q", got, tt.wantPayload)
+			}
+		})
+	}
+}
+
+func TestServeDC09UDP(t *testing.T) {
+	dispatcher := &fakeDispatcher{reply: ack}
+	server := runServer(t, &config.ServerConfig{Protocol: config.ProtocolDC09, UDP: true}, dispatcher, testRules())
+
+	conn, err := net.Dial("udp", server.Address())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// The UDP socket may open after the TCP one, and datagrams may be lost.
+	buf := make([]byte, 2048)
+	var n int
+	for attempt := 0; ; attempt++ {
+		if _, err := conn.Write(admFrame(21, "1234").Encode()); err != nil {
+			t.Fatal(err)
+		}
+		conn.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+		if n, err = conn.Read(buf); err == nil {
+			break
+		}
+		if attempt == 10 {
+			t.Fatalf("no UDP response: %v", err)
+		}
+	}
+
+	reply, err := dc09.Decode(buf[:n])
+	if err != nil {
+		t.Fatalf("Decode() error: %v", err)
+	}
+	if reply.ID != dc09.IDACK || reply.Sequence != 21 {
+		t.Errorf("response = %s seq %d, want ACK seq 21", reply.ID, reply.Sequence)
+	}
+	if payloads := dispatcher.dispatched(); payloads[0] != "5011 181234E13001003\x14" {
+		t.Errorf("dispatched %q", payloads)
+	}
+}
+
+func TestServeDC09UDP_ShutdownCancelsRelays(t *testing.T) {
+	// The relay is still waiting for the downstream reply when the server
+	// stops; it gives up at once instead of holding Run until it times out.
+	dispatcher := &fakeDispatcher{hold: true}
+	cfg := &config.ServerConfig{Protocol: config.ProtocolDC09, UDP: true, ReplyTimeout: time.Minute}
+	cfg.Host, cfg.Port = "127.0.0.1", freePort(t)
+	server := New(cfg, dispatcher, testRules())
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		server.Run(context.Background())
+	}()
+
+	conn, err := net.Dial("udp", server.Address())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	for start := time.Now(); dispatcher.count() == 0; time.Sleep(20 * time.Millisecond) {
+		if time.Since(start) > 2*time.Second {
+			t.Fatal("datagram never dispatched")
+		}
+		conn.Write(admFrame(22, "1234").Encode())
+	}
+
+	stopped := time.Now()
+	server.Stop()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run() did not return after Stop()")
+	}
+	if waited := time.Since(stopped); waited >= shutdownGrace {
+		t.Errorf("Run() returned after %s, the datagram handler outlived the shutdown grace period", waited)
+	}
+}
@@ -0,0 +1,166 @@
+package server
+
+import (
+	"cid_retranslator/cidParser"
+	"cid_retranslator/queue"
+	"sync"
+	"time"
+)
+
+// dedupKey identifies an event independent of receiver and line numbers.
+type dedupKey struct {
+	account   int
+	qualifier cidparser.Qualifier
+	code      int
+	group     int
+	zone      int
+}
+
+func keyOf(msg *cidparser.Message) dedupKey {
+	return dedupKey{
+		account:   msg.Account,
+		qualifier: msg.Qualifier,
+		code:      msg.Code,
+		group:     msg.Group,
+		zone:      msg.Zone,
+	}
+}
+
+// dedupCache remembers forwarded events for a time window so that copies
+// resent by a panel that missed our ACK are not forwarded again. A nil cache
+// disables suppression.
+type dedupCache struct {
+	window    time.Duration
+	mu        sync.Mutex
+	seen      map[dedupKey]time.Time
+	lastSweep time.Time
+	hits      int64
+}
+
+func newDedupCache(window time.Duration) *dedupCache {
+	if window <= 0 {
+		return nil
+	}
+	return &dedupCache{window: window, seen: make(map[dedupKey]time.Time)}
+}
+
+// isDuplicate reports whether the event was forwarded within the window and
+// counts it if so.
+func (d *dedupCache) isDuplicate(key dedupKey) bool {
+	if d == nil {
+		return false
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	at, ok := d.seen[key]
+	if !ok || time.Since(at) > d.window {
+		return false
+	}
+	d.hits++
+	return true
+}
+
+// remember records an event that was delivered or stored. Copies that arrive
+// while the original is still in flight are forwarded, so a failed delivery
+// never hides a retry.
+func (d *dedupCache) remember(key dedupKey) {
+	if d == nil {
+		return
+	}
+	now := time.Now()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.seen[key] = now
+	if now.Sub(d.lastSweep) > d.window {
+		for k, at := range d.seen {
+			if now.Sub(at) > d.window {
+				delete(d.seen, k)
+			}
+		}
+		d.lastSweep = now
+	}
+}
+
+// queued tracks messages still in the queue after their sender stopped
+// waiting for the result. A copy that arrives meanwhile waits for the
+// original instead of being queued a second time.
+type queued struct {
+	mu       sync.Mutex
+	messages map[dedupKey]*queuedMessage
+}
+
+// queuedMessage is closed once the original is delivered or failed.
+type queuedMessage struct {
+	done  chan struct{}
+	acked bool
+}
+
+func newQueued() *queued {
+	return &queued{messages: make(map[dedupKey]*queuedMessage)}
+}
+
+// track watches replyCh of a message whose sender gave up waiting and calls
+// delivered if it is acknowledged after all.
+func (q *queued) track(key dedupKey, replyCh <-chan queue.DeliveryData, delivered func()) {
+	m := &queuedMessage{done: make(chan struct{})}
+	q.mu.Lock()
+	q.messages[key] = m
+	q.mu.Unlock()
+
+	go func() {
+		reply, ok := <-replyCh
+		m.acked = ok && reply.Status
+		if m.acked {
+			delivered()
+		}
+		q.mu.Lock()
+		if q.messages[key] == m {
+			delete(q.messages, key)
+		}
+		q.mu.Unlock()
+		close(m.done)
+	}()
+}
+
+// lookup returns the queued original of a message, or nil.
+func (q *queued) lookup(key dedupKey) *queuedMessage {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.messages[key]
+}
+
+func (d *dedupCache) count() int64 {
+	if d == nil {
+		return 0
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.hits
+}
+
+// GetDuplicates returns how many duplicate messages were ACKed without being
+// forwarded.
+func (server *Server) GetDuplicates() int64 {
+	return server.dedup.count()
+}
+
+// noteDevice adds an entry to a known device's event history without
+// changing its last event.
+func (server *Server) noteDevice(id int, note string) {
+	nowStr := time.Now().Format("2006-01-02 15:04:05")
+
+	server.deviceMu.Lock()
+	defer server.deviceMu.Unlock()
+
+	for i := range server.devices {
+		if server.devices[i].ID == id {
+			server.devices[i].Events = append(server.devices[i].Events, Event{Time: nowStr, Data: note})
+			if len(server.devices[i].Events) > 100 {
+				server.devices[i].Events = server.devices[i].Events[len(server.devices[i].Events)-100:]
+			}
+			return
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package server
+
+import (
+	"cid_retranslator/config"
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRelay_SuppressesDuplicates(t *testing.T) {
+	tests := []struct {
+		name           string
+		window         time.Duration
+		second         string
+		wantDispatched int
+		wantDuplicates int64
+	}{
+		{"copy within window", time.Minute, testMessage, 1, 1},
+		{"other zone", time.Minute, "5000 181234E13000002\x14", 2, 0},
+		{"suppression disabled", 0, testMessage, 2, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dispatcher := &fakeDispatcher{reply: ack}
+			server := New(&config.ServerConfig{DedupWindow: tt.window}, dispatcher, testRules())
+
+			for _, frame := range []string{testMessage, tt.second} {
+				if ack, ok := server.relay(context.Background(), testAddr, []byte(frame)); !ack || !ok {
+					t.Fatalf("relay(%q) = %v, %v, want ACK", frame, ack, ok)
+				}
+			}
+			if n := dispatcher.count(); n != tt.wantDispatched {
+				t.Errorf("dispatched %d times, want %d", n, tt.wantDispatched)
+			}
+			if n := server.GetDuplicates(); n != tt.wantDuplicates {
+				t.Errorf("GetDuplicates() = %d, want %d", n, tt.wantDuplicates)
+			}
+
+			events := server.GetDeviceEvents(1234)
+			noted := len(events) > 0 && strings.HasPrefix(events[len(events)-1].Data, "Duplicate suppressed")
+			if noted != (tt.wantDuplicates > 0) {
+				t.Errorf("device events = %+v, want duplicate noted %v", events, tt.wantDuplicates > 0)
+			}
+		})
+	}
+}
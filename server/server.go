@@ -4,12 +4,14 @@ import (
 	"bufio"
 	"cid_retranslator/cidParser"
 	"cid_retranslator/config"
+	"cid_retranslator/dc09"
 	"cid_retranslator/queue"
 	"context"
-	"io"
-	"log"
+	"crypto/tls"
+	"errors"
 	"log/slog"
 	"net"
+	"regexp"
 	"slices"
 	"strconv"
 	"sync"
@@ -17,9 +19,15 @@ import (
 )
 
 type Server struct {
+	name               string
 	host               string
 	port               string
-	queue              *queue.Queue
+	protocol           string
+	udp                bool
+	ackMode            string
+	replyTimeout       time.Duration
+	dc09Keys           dc09.Keyring
+	dispatcher         queue.Dispatcher
 	rules              *config.CIDRules
 	cancel             context.CancelFunc
 	stopOnce           sync.Once
@@ -29,6 +37,31 @@ type Server struct {
 	deviceMu           sync.RWMutex
 	globalEvents       []GlobalEvent
 	globalMu           sync.RWMutex
+	filterHits         []int
+	filterMu           sync.Mutex
+	heartbeats         []*regexp.Regexp
+	dedup              *dedupCache
+	queued             *queued
+	conns              map[uint64]*connection
+	connMu             sync.Mutex
+	tlsEnabled         bool
+	tlsConfig          *tls.Config // nil when TLS is off or misconfigured
+	access             *access
+	idleTimeout        time.Duration
+	readTimeout        time.Duration
+	keepAlive          time.Duration
+	keepAliveCount     int
+	handlers           sync.WaitGroup
+}
+
+// defaultReplyTimeout is used when the listener has no reply timeout configured.
+const defaultReplyTimeout = 10 * time.Second
+
+// FilterStat reports how many messages matched a filter rule.
+type FilterStat struct {
+	Name   string `json:"name"`
+	Action string `json:"action"`
+	Hits   int    `json:"hits"`
 }
 
 // Event represents an event for a device
@@ -40,6 +73,7 @@ type Event struct {
 // Device represents a device with its events
 type Device struct {
 	ID           int      `json:"id"`
+	Listener     string   `json:"listener"`
 	LastEventTime string  `json:"lastEventTime"`
 	LastEvent    string   `json:"lastEvent"`
 	Events       []Event  `json:"events"`
@@ -49,42 +83,116 @@ type Device struct {
 type GlobalEvent struct {
 	Time     string `json:"time"`
 	DeviceID int    `json:"deviceID"`
+	Listener string `json:"listener"`
 	Data     string `json:"data"`
 }
 
 // connection represents a client connection to the server.
 type connection struct {
-	conn   net.Conn
-	queue  *queue.Queue
-	rules  *config.CIDRules
-	server *Server // Reference to server for access to devices
+	id            uint64
+	conn          net.Conn
+	server        *Server // Reference to server for access to devices
+	connectedAt   time.Time
+	mu            sync.Mutex
+	lastHeartbeat time.Time
+	lastMessage   time.Time
+	bytesIn       int64
+	messagesIn    int64
 }
 
-func New(cfg *config.ServerConfig, q *queue.Queue, rules *config.CIDRules) *Server {
+func New(cfg *config.ServerConfig, dispatcher queue.Dispatcher, rules *config.CIDRules) *Server {
+	keys, err := dc09.NewKeyring(cfg.DC09Keys)
+	if err != nil {
+		slog.Error("Invalid DC-09 keys, encrypted messages will be rejected", "error", err)
+	}
+	heartbeats := make([]*regexp.Regexp, 0, len(cfg.Heartbeats))
+	for _, pattern := range cfg.Heartbeats {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			slog.Error("Invalid heartbeat pattern, ignoring", "pattern", pattern, "error", err)
+			continue
+		}
+		heartbeats = append(heartbeats, re)
+	}
+	if rules.AccountMap.File != "" {
+		if err := cidparser.LoadAccountMap(&rules.AccountMap); err != nil {
+			slog.Error("Account map unavailable, applying the unknown-account policy until it loads", "error", err)
+		}
+	}
+	replyTimeout := cfg.ReplyTimeout
+	if replyTimeout <= 0 {
+		replyTimeout = defaultReplyTimeout
+	}
+	var tlsConfig *tls.Config
+	if cfg.TLS.Enabled {
+		if tlsConfig, err = cfg.TLS.ServerTLS(); err != nil {
+			slog.Error("Invalid TLS settings, the server will not start", "error", err)
+		}
+	}
 	return &Server{
+		name:        cfg.Name,
 		host:        cfg.Host,
 		port:        cfg.Port,
-		queue:       q,
+		protocol:    cfg.Protocol,
+		udp:         cfg.UDP,
+		ackMode:     cfg.AckMode,
+		replyTimeout: replyTimeout,
+		dc09Keys:    keys,
+		dispatcher:  dispatcher,
 		rules:       rules,
 		devices:     make([]Device, 0),
 		globalEvents: make([]GlobalEvent, 0),
+		filterHits:  make([]int, len(rules.Filters)),
+		heartbeats:  heartbeats,
+		dedup:       newDedupCache(cfg.DedupWindow),
+		queued:      newQueued(),
+		conns:       make(map[uint64]*connection),
+		tlsEnabled:  cfg.TLS.Enabled,
+		tlsConfig:   tlsConfig,
+		access:      newAccess(cfg.Access),
+		idleTimeout: cfg.IdleTimeout,
+		readTimeout: cfg.ReadTimeout,
+		keepAlive:   cfg.KeepAlive,
+		keepAliveCount: cfg.KeepAliveCount,
 	}
 }
 
 func (server *Server) Run(ctx context.Context) {
 	ctx, cancel := context.WithCancel(ctx)
 	server.cancel = cancel
-	server.queue.UpdateStartTime()
 
-	listener, err := net.Listen("tcp", server.host+":"+server.port)
+	if server.tlsEnabled && server.tlsConfig == nil {
+		slog.Error("Failed to start server", "error", "invalid TLS settings")
+		return
+	}
+	listenConfig := net.ListenConfig{
+		KeepAliveConfig: net.KeepAliveConfig{
+			Enable:   true,
+			Idle:     server.keepAlive,
+			Interval: server.keepAlive,
+			Count:    server.keepAliveCount,
+		},
+	}
+	if server.keepAlive < 0 {
+		listenConfig = net.ListenConfig{KeepAlive: -1}
+	}
+	listener, err := listenConfig.Listen(ctx, "tcp", server.Address())
 	if err != nil {
 		slog.Error("Failed to start server", "error", err)
 		return
 	}
+	if server.tlsConfig != nil {
+		listener = tls.NewListener(listener, server.tlsConfig)
+	}
 	server.listener = listener
 	server.isRunning = true
 
-	slog.Info("Server started", "host", server.host, "port", server.port)
+	slog.Info("Server started", "listener", server.name, "host", server.host, "port", server.port, "protocol", server.protocolName(), "tls", server.tlsEnabled)
+
+	if server.protocol == config.ProtocolDC09 && server.udp {
+		server.handlers.Add(1)
+		go server.serveDC09UDP(ctx)
+	}
 
 	go func() {
 		defer server.listener.Close()
@@ -100,15 +208,48 @@ func (server *Server) Run(ctx context.Context) {
 				}
 				continue
 			}
+			if reason := server.access.admit(conn.RemoteAddr(), server.connCount()); reason != "" {
+				slog.Warn("Connection rejected", "from", conn.RemoteAddr(), "reason", reason)
+				conn.Close()
+				continue
+			}
 			slog.Info("Accepted connection", "from", conn.RemoteAddr())
-			connHandler := &connection{conn: conn, queue: server.queue, rules: server.rules, server: server}
-			go connHandler.handleRequest(ctx)
+			connHandler := server.track(conn)
+			server.handlers.Add(1)
+			if server.protocol == config.ProtocolDC09 {
+				go connHandler.handleDC09(ctx)
+			} else {
+				go connHandler.handleRequest(ctx)
+			}
 		}
 	}()
 
 	<-ctx.Done()
 	slog.Info("Server stopping...")
 	server.isRunning = false
+	server.closeConnections()
+}
+
+// Name returns the listener name from the configuration.
+func (server *Server) Name() string {
+	return server.name
+}
+
+// Address returns the host and port the listener binds to.
+func (server *Server) Address() string {
+	return net.JoinHostPort(server.host, server.port)
+}
+
+// Protocol returns the protocol of the listener.
+func (server *Server) Protocol() string {
+	return server.protocolName()
+}
+
+func (server *Server) protocolName() string {
+	if server.protocol == "" {
+		return config.ProtocolSurgard
+	}
+	return server.protocol
 }
 
 func (server *Server) Stop() {
@@ -148,6 +289,7 @@ func (server *Server) UpdateDevice(id int, event string) {
 	if !found {
 		newDevice := Device{
 			ID:           id,
+			Listener:     server.name,
 			LastEventTime: nowStr,
 			LastEvent:    event,
 			Events:       []Event{{Time: nowStr, Data: event}},
@@ -157,7 +299,7 @@ func (server *Server) UpdateDevice(id int, event string) {
 
 	// Add to global events
 	server.globalMu.Lock()
-	server.globalEvents = append(server.globalEvents, GlobalEvent{Time: nowStr, DeviceID: id, Data: event})
+	server.globalEvents = append(server.globalEvents, GlobalEvent{Time: nowStr, DeviceID: id, Listener: server.name, Data: event})
 	if len(server.globalEvents) > 500 {
 		server.globalEvents = server.globalEvents[len(server.globalEvents)-500:]
 	}
@@ -173,6 +315,7 @@ func (server *Server) GetDevices() []Device {
 	for i, d := range server.devices {
 		devs[i] = Device{
 			ID:           d.ID,
+			Listener:     d.Listener,
 			LastEventTime: d.LastEventTime,
 			LastEvent:    d.LastEvent,
 			// Events omitted for summary
@@ -203,12 +346,41 @@ func (server *Server) GetDeviceEvents(id int) []Event {
 	return []Event{}
 }
 
+// GetFilterStats returns per-rule filter counters in configuration order
+func (server *Server) GetFilterStats() []FilterStat {
+	server.filterMu.Lock()
+	defer server.filterMu.Unlock()
+
+	stats := make([]FilterStat, len(server.rules.Filters))
+	for i, f := range server.rules.Filters {
+		name := f.Name
+		if name == "" {
+			name = "filter #" + strconv.Itoa(i+1)
+		}
+		stats[i] = FilterStat{Name: name, Action: f.Action, Hits: server.filterHits[i]}
+	}
+	return stats
+}
+
+// CertExpiry returns when the listener certificate expires, zero without TLS.
+func (server *Server) CertExpiry() time.Time {
+	return config.CertExpiry(server.tlsConfig)
+}
+
+func (server *Server) countFilterHit(rule int) {
+	server.filterMu.Lock()
+	server.filterHits[rule]++
+	server.filterMu.Unlock()
+}
+
 func (c *connection) handleRequest(ctx context.Context) {
 	remoteAddr := c.conn.RemoteAddr()
 	slog.Debug("Handling request", "from", remoteAddr)
+	defer c.server.handlers.Done()
+	defer c.server.untrack(c)
 	defer c.conn.Close()
 
-	reader := bufio.NewReader(c.conn)
+	reader := bufio.NewReader(c)
 	for {
 		select {
 		case <-ctx.Done():
@@ -217,13 +389,13 @@ func (c *connection) handleRequest(ctx context.Context) {
 		default:
 		}
 
+		if err := c.awaitFrame(reader); err != nil {
+			c.readFailed(err, true)
+			return
+		}
 		messageBytes, err := reader.ReadBytes(0x14)
 		if err != nil {
-			if err != io.EOF {
-				slog.Error("Read error", "from", remoteAddr, "error", err)
-			} else {
-				slog.Info("Connection closed by client", "client", remoteAddr)
-			}
+			c.readFailed(err, false)
 			return
 		}
 
@@ -236,75 +408,146 @@ func (c *connection) handleRequest(ctx context.Context) {
 		}
 		slog.Debug("Received message", "from", remoteAddr, "data", string(messageBytes))
 
-		messageWithoutDelimiter := string(messageBytes)
-		if !cidparser.IsMessageValid(messageWithoutDelimiter, c.rules) {
-			slog.Warn("Invalid message format", "from", remoteAddr, "data", string(messageBytes))
-			if _, err := c.conn.Write([]byte{0x15}); err != nil {
-				slog.Error("Error sending NACK for invalid format", "error", err)
+		if c.server.isHeartbeat(messageBytes) {
+			c.recordHeartbeat()
+			if _, err := c.conn.Write([]byte{0x06}); err != nil {
+				slog.Error("Error sending ACK for heartbeat", "error", err)
+				return
 			}
+			slog.Debug("Heartbeat acknowledged", "from", remoteAddr)
 			continue
 		}
+		c.recordMessage()
 
-		newMessage, err := cidparser.ChangeAccountNumber(messageBytes, c.rules)
-		if err != nil {
-			slog.Error("Error processing message", "from", remoteAddr, "error", err)
-			if _, err := c.conn.Write([]byte{0x15}); err != nil {
-				slog.Error("Error sending NACK for processing error", "error", err)
-			}
-			continue
+		ack, ok := c.server.relay(ctx, remoteAddr, messageBytes)
+		if !ok {
+			return
 		}
 
-		replyCh := make(chan queue.DeliveryData, 1)
-		sharedData := queue.SharedData{
-			Payload: newMessage,
-			ReplyCh: replyCh,
+		response, responseType := []byte{0x15}, "NACK"
+		if ack {
+			response, responseType = []byte{0x06}, "ACK"
+		}
+		if _, err := c.conn.Write(response); err != nil {
+			slog.Error("Error sending response", "type", responseType, "error", err)
+			return
 		}
+		slog.Info("Message relayed", "from", remoteAddr, "status", responseType, "data", string(messageBytes))
+	}
+}
 
-		select {
-		case c.queue.DataChannel <- sharedData:
-			log.Println(c.queue.DataChannel)
-			// Add event for device
-			deviceID := extractDeviceID(newMessage)
-			c.server.UpdateDevice(deviceID, string(newMessage))
-
-			select {
-			case clientReply, ok := <-replyCh:
-				if !ok {
-					slog.Warn("Reply channel closed unexpectedly", "from", remoteAddr)
-					return
-				}
+// relay runs a Contact ID frame through filters, rewriting and the queue and
+// reports whether the sender should get an ACK. ok is false when the
+// connection should be dropped without a response, e.g. on shutdown.
+func (server *Server) relay(ctx context.Context, remoteAddr net.Addr, messageBytes []byte) (ack bool, ok bool) {
+	if !server.access.allowMessage(remoteAddr) {
+		slog.Warn("Rate limit exceeded, rejecting message", "from", remoteAddr, "data", string(messageBytes))
+		return false, true
+	}
 
-				response, responseType := []byte{0x15}, "NACK"
-				if clientReply.Status {
-					response, responseType = []byte{0x06}, "ACK"
-				}
+	if !cidparser.IsMessageValid(string(messageBytes), server.rules) {
+		slog.Warn("Invalid message format", "from", remoteAddr, "data", string(messageBytes))
+		return false, true
+	}
 
-				if _, err := c.conn.Write(response); err != nil {
-					slog.Error("Error sending response", "type", responseType, "error", err)
-					return
-				}
-				slog.Info("Message relayed", "from", remoteAddr, "status", responseType, "data", string(messageBytes))
+	if msg, err := cidparser.Parse(messageBytes); err == nil {
+		action, rule := cidparser.ApplyFilters(msg, server.rules)
+		if rule >= 0 {
+			server.countFilterHit(rule)
+		}
+		if action != config.FilterForward {
+			slog.Info("Message dropped by filter", "from", remoteAddr, "filter", server.rules.Filters[rule].Name, "action", action, "data", string(messageBytes))
+			return action == config.FilterDropACK, true
+		}
+	}
 
-			case <-time.After(10 * time.Second):
-				slog.Error("Timeout waiting for client reply", "from", remoteAddr)
-				if _, err := c.conn.Write([]byte{0x15}); err != nil {
-					slog.Error("Error sending NACK after timeout", "error", err)
-				}
-			}
-		default:
-			slog.Warn("Queue buffer full, rejecting message", "from", remoteAddr)
-			if _, err := c.conn.Write([]byte{0x15}); err != nil {
-				slog.Error("Error sending NACK for buffer full", "error", err)
+	newMessage, err := cidparser.ChangeAccountNumber(messageBytes, server.rules)
+	if err != nil {
+		slog.Error("Error processing message", "from", remoteAddr, "error", err)
+		return false, true
+	}
+
+	var key dedupKey
+	keyed := false
+	if msg, err := cidparser.Parse(newMessage); err == nil {
+		key, keyed = keyOf(msg), true
+		if server.dedup.isDuplicate(key) {
+			slog.Info("Duplicate message acknowledged without forwarding", "from", remoteAddr, "data", string(newMessage))
+			server.noteDevice(msg.Account, "Duplicate suppressed: "+string(newMessage))
+			return true, true
+		}
+	}
+
+	// A copy of a message that timed out but is still queued gets the
+	// original's result. It is only queued itself if the original failed.
+	if original := server.queued.lookup(key); keyed && original != nil {
+		slog.Info("Waiting for the queued original of a resent message", "from", remoteAddr, "data", string(newMessage))
+		select {
+		case <-original.done:
+			if original.acked {
+				return true, true
 			}
+		case <-time.After(server.replyTimeout):
+			slog.Warn("Original of a resent message still queued, sending NACK", "from", remoteAddr)
+			return false, true
+		case <-ctx.Done():
+			return false, false
+		}
+	}
+
+	// In store mode nobody waits for the reply; the client retries on its own.
+	replyCh, err := server.dispatcher.Dispatch(newMessage, server.ackMode != config.AckStore)
+	if err != nil {
+		if errors.Is(err, queue.ErrFull) {
+			slog.Warn("Queue buffer full, rejecting message", "from", remoteAddr, "error", err)
+		} else {
+			slog.Error("Cannot queue message", "from", remoteAddr, "error", err)
 		}
+		return false, true
+	}
+
+	// Add event for device
+	deviceID := extractDeviceID(newMessage)
+	server.UpdateDevice(deviceID, string(newMessage))
+
+	if replyCh == nil {
+		slog.Debug("Message stored for delivery", "from", remoteAddr)
+		server.dedup.remember(key)
+		return true, true
+	}
+
+	select {
+	case clientReply, ok := <-replyCh:
+		if !ok {
+			slog.Warn("Reply channel closed unexpectedly", "from", remoteAddr)
+			return false, false
+		}
+		if clientReply.Status {
+			server.dedup.remember(key)
+		}
+		return clientReply.Status, true
+
+	case <-time.After(server.replyTimeout):
+		slog.Error("Timeout waiting for client reply", "from", remoteAddr)
+		ack, ok = false, true
+
+	case <-ctx.Done():
+		slog.Info("Server stopping, closing connection without reply", "from", remoteAddr)
+		ack, ok = false, false
+	}
+
+	// The message stays queued; a retransmit waits for its result.
+	if keyed {
+		server.queued.track(key, replyCh, func() { server.dedup.remember(key) })
 	}
+	return ack, ok
 }
 
 func extractDeviceID(message []byte) int {
-	accountNumber, err := strconv.Atoi(string(message[7:11]))
+	msg, err := cidparser.Parse(message)
 	if err != nil {
 		slog.Error("Failed to extract device ID", "error", err)
 		return 0
 	}
-	return accountNumber
+	return msg.Account
 }
\ No newline at end of file
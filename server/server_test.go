@@ -0,0 +1,386 @@
+package server
+
+import (
+	"cid_retranslator/config"
+	"cid_retranslator/dc09"
+	"cid_retranslator/dispatch"
+	"cid_retranslator/queue"
+	"context"
+	"io"
+	"net"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+const testMessage = "5000 181234E13000001\x14"
+
+var (
+	ack  = queue.DeliveryData{Status: true, Response: "ACK"}
+	nack = queue.DeliveryData{Status: false, Response: "NAK"}
+)
+
+// fakeDispatcher records the dispatched payloads and answers with reply, or
+// keeps the reply channels for answer when hold is set.
+type fakeDispatcher struct {
+	mu       sync.Mutex
+	payloads []string
+	reply    queue.DeliveryData
+	hold     bool
+	held     []chan queue.DeliveryData
+}
+
+func (f *fakeDispatcher) Dispatch(payload []byte, wait bool) (<-chan queue.DeliveryData, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.payloads = append(f.payloads, string(payload))
+	if !wait {
+		return nil, nil
+	}
+	ch := make(chan queue.DeliveryData, 1)
+	if f.hold {
+		f.held = append(f.held, ch)
+	} else {
+		ch <- f.reply
+		close(ch)
+	}
+	return ch, nil
+}
+
+// answer delivers the result of the i-th held message.
+func (f *fakeDispatcher) answer(i int, reply queue.DeliveryData) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.held[i] <- reply
+	close(f.held[i])
+}
+
+func (f *fakeDispatcher) count() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.payloads)
+}
+
+func (f *fakeDispatcher) dispatched() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return slices.Clone(f.payloads)
+}
+
+func testRules() *config.CIDRules {
+	return &config.CIDRules{RequiredPrefix: "5", ValidLength: 21}
+}
+
+var testAddr = &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 40000}
+
+// serve hands one end of a pipe to a connection handler of server and
+// returns the other end, which fails reads and writes after two seconds.
+func serve(t *testing.T, server *Server) net.Conn {
+	t.Helper()
+	client, conn := net.Pipe()
+	client.SetDeadline(time.Now().Add(2 * time.Second))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	c := server.track(conn)
+	server.handlers.Add(1)
+	if server.protocol == config.ProtocolDC09 {
+		go c.handleDC09(ctx)
+	} else {
+		go c.handleRequest(ctx)
+	}
+	t.Cleanup(func() {
+		cancel()
+		client.Close()
+		server.handlers.Wait()
+	})
+	return client
+}
+
+// freePort returns a loopback port nothing listens on.
+func freePort(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	_, port, _ := net.SplitHostPort(ln.Addr().String())
+	return port
+}
+
+// runServer starts a listener on a free loopback port and waits until it
+// accepts connections. It is stopped when the test ends.
+func runServer(t *testing.T, cfg *config.ServerConfig, dispatcher queue.Dispatcher, rules *config.CIDRules) *Server {
+	t.Helper()
+	cfg.Host, cfg.Port = "127.0.0.1", freePort(t)
+	server := New(cfg, dispatcher, rules)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		server.Run(context.Background())
+	}()
+	t.Cleanup(func() {
+		server.Stop()
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Error("Run() did not return after Stop()")
+		}
+	})
+
+	// Wait until the probe has been accepted and closed again, so it does
+	// not show up in the test.
+	var probe net.Conn
+	for start := time.Now(); ; time.Sleep(10 * time.Millisecond) {
+		var err error
+		if probe, err = net.Dial("tcp", server.Address()); err == nil {
+			break
+		}
+		if time.Since(start) > 2*time.Second {
+			t.Fatalf("server not listening: %v", err)
+		}
+	}
+	eventually(t, func() bool { return server.connCount() == 1 })
+	probe.Close()
+	eventually(t, func() bool { return server.connCount() == 0 })
+	return server
+}
+
+// dial connects to a running server.
+func dial(t *testing.T, server *Server) net.Conn {
+	t.Helper()
+	conn, err := net.Dial("tcp", server.Address())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// eventually waits up to two seconds for cond to hold.
+func eventually(t *testing.T, cond func() bool) {
+	t.Helper()
+	for start := time.Now(); !cond(); time.Sleep(5 * time.Millisecond) {
+		if time.Since(start) > 2*time.Second {
+			t.Fatal("condition not met within 2s")
+		}
+	}
+}
+
+func TestRelay_RetransmitWaitsForQueuedOriginal(t *testing.T) {
+	tests := []struct {
+		name           string
+		original       *queue.DeliveryData // late result of the first copy, nil for none
+		wantAck        bool
+		wantDispatched int
+	}{
+		{"original delivered", &ack, true, 1},
+		{"original failed", &nack, true, 2},
+		{"original still queued", nil, false, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Without a dedup window the retransmit must still not be
+			// queued next to its original.
+			dispatcher := &fakeDispatcher{hold: true}
+			server := New(&config.ServerConfig{ReplyTimeout: 100 * time.Millisecond}, dispatcher, testRules())
+
+			if ack, ok := server.relay(context.Background(), testAddr, []byte(testMessage)); ack || !ok {
+				t.Fatalf("relay() = %v, %v, want NACK after timeout", ack, ok)
+			}
+
+			// The panel resends after the NACK while the first copy is
+			// still queued.
+			type result struct{ ack, ok bool }
+			resent := make(chan result, 1)
+			go func() {
+				ack, ok := server.relay(context.Background(), testAddr, []byte(testMessage))
+				resent <- result{ack, ok}
+			}()
+			if tt.original != nil {
+				time.Sleep(20 * time.Millisecond)
+				dispatcher.answer(0, *tt.original)
+			}
+			if tt.wantDispatched == 2 {
+				// The failed original's copy is queued in its place.
+				eventually(t, func() bool { return dispatcher.count() == 2 })
+				dispatcher.answer(1, ack)
+			}
+
+			r := <-resent
+			if r.ack != tt.wantAck || !r.ok {
+				t.Errorf("relay() of retransmit = %v, %v, want ACK %v", r.ack, r.ok, tt.wantAck)
+			}
+			if n := dispatcher.count(); n != tt.wantDispatched {
+				t.Errorf("dispatched %d times, want %d", n, tt.wantDispatched)
+			}
+		})
+	}
+}
+
+func TestRelay_ReturnsOnShutdown(t *testing.T) {
+	dispatcher := &fakeDispatcher{hold: true}
+	server := New(&config.ServerConfig{ReplyTimeout: time.Minute}, dispatcher, testRules())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+	start := time.Now()
+	if ack, ok := server.relay(ctx, testAddr, []byte(testMessage)); ack || ok {
+		t.Errorf("relay() = %v, %v, want the connection dropped", ack, ok)
+	}
+	if waited := time.Since(start); waited > time.Second {
+		t.Errorf("relay() returned after %v, want right after shutdown", waited)
+	}
+}
+
+// exchange sends a Surgard frame and returns the one-byte response.
+func exchange(t *testing.T, conn net.Conn, frame string) byte {
+	t.Helper()
+	if _, err := conn.Write([]byte(frame)); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	buf := make([]byte, 1)
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		t.Fatalf("read response: %v", err)
+	}
+	return buf[0]
+}
+
+// closed reports whether the server closed conn without sending anything.
+func closed(conn net.Conn) bool {
+	_, err := conn.Read(make([]byte, 1))
+	return err == io.EOF
+}
+
+func TestHandleRequest(t *testing.T) {
+	const heartbeat = "1011           @    \x14"
+	filter := func(action string) *config.CIDRules {
+		rules := testRules()
+		rules.Filters = []config.FilterRule{{Name: "burglary", Match: config.MessageMatch{Codes: config.Range{Min: 130, Max: 130, Set: true}}, Action: action}}
+		return rules
+	}
+
+	tests := []struct {
+		name           string
+		rules          *config.CIDRules
+		reply          queue.DeliveryData
+		frame          string
+		want           byte
+		wantDispatched int
+		wantHeartbeat  bool
+	}{
+		{"delivered", testRules(), ack, testMessage, 0x06, 1, false},
+		{"downstream NAK", testRules(), nack, testMessage, 0x15, 1, false},
+		{"wrong prefix", testRules(), ack, "6000 181234E13000001\x14", 0x15, 0, false},
+		{"wrong length", testRules(), ack, "5000 181234E130000\x14", 0x15, 0, false},
+		{"filter drop-ack", filter(config.FilterDropACK), ack, testMessage, 0x06, 0, false},
+		{"filter drop-nack", filter(config.FilterDropNACK), ack, testMessage, 0x15, 0, false},
+		{"filter other code", filter(config.FilterDropNACK), ack, "5000 181234E60200001\x14", 0x06, 1, false},
+		{"heartbeat", testRules(), nack, heartbeat, 0x06, 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dispatcher := &fakeDispatcher{reply: tt.reply}
+			cfg := &config.ServerConfig{Heartbeats: []string{`^1011\s+@\s+$`}}
+			server := New(cfg, dispatcher, tt.rules)
+			conn := serve(t, server)
+
+			if got := exchange(t, conn, tt.frame); got != tt.want {
+				t.Errorf("response = 0x%02X, want 0x%02X", got, tt.want)
+			}
+			if n := dispatcher.count(); n != tt.wantDispatched {
+				t.Errorf("dispatched %d messages, want %d", n, tt.wantDispatched)
+			}
+			heartbeats := server.GetHeartbeats()
+			if len(heartbeats) != 1 || (heartbeats[0].LastHeartbeat != "") != tt.wantHeartbeat {
+				t.Errorf("heartbeats = %+v, want heartbeat recorded %v", heartbeats, tt.wantHeartbeat)
+			}
+		})
+	}
+}
+
+func TestRelay_StoreMode(t *testing.T) {
+	// The sender gets its ACK once the message is queued, not when the
+	// downstream receiver answers.
+	dispatcher := &fakeDispatcher{hold: true}
+	server := New(&config.ServerConfig{AckMode: config.AckStore}, dispatcher, testRules())
+
+	if ack, ok := server.relay(context.Background(), testAddr, []byte(testMessage)); !ack || !ok {
+		t.Errorf("relay() = %v, %v, want ACK", ack, ok)
+	}
+	if n := dispatcher.count(); n != 1 {
+		t.Errorf("dispatched %d times, want 1", n)
+	}
+	if dispatcher.held != nil {
+		t.Error("store mode waited for the downstream reply")
+	}
+}
+
+func TestListeners_Routing(t *testing.T) {
+	north, south := queue.New(10), queue.New(10)
+	router, err := dispatch.NewRouter(
+		[]config.RouteConfig{{
+			Name:    "north",
+			Match:   config.RouteMatch{MessageMatch: config.MessageMatch{Accounts: config.Range{Min: 5000, Max: 5999, Set: true}}},
+			Clients: []string{"north"},
+		}},
+		nil,
+		[]dispatch.Member{{Name: "north", Queue: north}, {Name: "south", Queue: south}},
+		config.FanoutAll, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	northRoute, err := router.For("north")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The alarm listener adds 100 to every account and only feeds the
+	// north route; the backup listener speaks DC-09 and uses the table,
+	// where account 1234 takes the default route to both clients.
+	alarmRules := testRules()
+	alarmRules.AccountRules = []config.AccountRule{{Accounts: config.Range{Min: 1, Max: 9000, Set: true}, Action: config.AccountActionAdd, Value: 100}}
+	alarm := runServer(t, &config.ServerConfig{Name: "alarm", AckMode: config.AckStore}, northRoute, alarmRules)
+	backup := runServer(t, &config.ServerConfig{Name: "backup", Protocol: config.ProtocolDC09, AckMode: config.AckStore}, router, testRules())
+
+	if got := exchange(t, dial(t, alarm), testMessage); got != 0x06 {
+		t.Fatalf("alarm response = 0x%02X, want ACK", got)
+	}
+	if got := dc09Exchange(t, dial(t, backup), admFrame(1, "1234")); got.ID != dc09.IDACK {
+		t.Fatalf("backup response = %s, want ACK", got.ID)
+	}
+
+	tests := []struct {
+		name string
+		q    *queue.Queue
+		want []string
+	}{
+		{"north", north, []string{"5000 181334E13000001\x14", "5011 181234E13001003\x14"}},
+		{"south", south, []string{"5011 181234E13001003\x14"}},
+	}
+	for _, tt := range tests {
+		var got []string
+		for {
+			data, err := tt.q.TryPop()
+			if err != nil {
+				break
+			}
+			got = append(got, string(data.Payload))
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("%s queue = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+
+	if devices := alarm.GetDevices(); len(devices) != 1 || devices[0].ID != 1334 || devices[0].Listener != "alarm" {
+		t.Errorf("alarm devices = %+v, want account 1334 on alarm", devices)
+	}
+	if devices := backup.GetDevices(); len(devices) != 1 || devices[0].ID != 1234 || devices[0].Listener != "backup" {
+		t.Errorf("backup devices = %+v, want account 1234 on backup", devices)
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"cid_retranslator/config"
+	"cid_retranslator/dispatch"
+	"cid_retranslator/queue"
+	"cid_retranslator/server"
+	"fmt"
+)
+
+// upstream is one inbound listener with its own rules and route.
+type upstream struct {
+	name   string
+	route  string // empty when the routing table decides
+	server *server.Server
+}
+
+// openUpstreams creates a server for every configured listener. Listeners
+// bound to a route skip the routing table and always use that route.
+func openUpstreams(cfg *config.Config, router *dispatch.Router) ([]*upstream, error) {
+	listeners := cfg.EffectiveListeners()
+	upstreams := make([]*upstream, 0, len(listeners))
+	for i := range listeners {
+		listenerCfg := &listeners[i]
+		var dispatcher queue.Dispatcher = router
+		if listenerCfg.Route != "" {
+			d, err := router.For(listenerCfg.Route)
+			if err != nil {
+				return nil, fmt.Errorf("listener %s: %w", listenerCfg.Name, err)
+			}
+			dispatcher = d
+		}
+		upstreams = append(upstreams, &upstream{
+			name:   listenerCfg.Name,
+			route:  listenerCfg.Route,
+			server: server.New(&listenerCfg.ServerConfig, dispatcher, &listenerCfg.CIDRules),
+		})
+	}
+	return upstreams, nil
+}
+
+// ListenerStats reports one inbound listener.
+type ListenerStats struct {
+	Name        string              `json:"name"`
+	Address     string              `json:"address"`
+	Protocol    string              `json:"protocol"`
+	Route       string              `json:"route"`
+	Connections int                 `json:"connections"`
+	Devices     int                 `json:"devices"`
+	Filters     []server.FilterStat `json:"filters"`
+	Duplicates  int64               `json:"duplicates"`
+	// CertExpiry is when the listener TLS certificate expires, empty
+	// without TLS.
+	CertExpiry string             `json:"certExpiry"`
+	Access     server.AccessStats `json:"access"`
+}
+
+func (u *upstream) stats() ListenerStats {
+	return ListenerStats{
+		Name:        u.name,
+		Address:     u.server.Address(),
+		Protocol:    u.server.Protocol(),
+		Route:       u.route,
+		Connections: len(u.server.GetConnections()),
+		Devices:     len(u.server.GetDevices()),
+		Filters:     u.server.GetFilterStats(),
+		Duplicates:  u.server.GetDuplicates(),
+		CertExpiry:  formatTime(u.server.CertExpiry()),
+		Access:      u.server.GetAccessStats(),
+	}
+}